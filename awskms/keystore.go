@@ -0,0 +1,75 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+// Package awskms implements a gocbfieldcrypt.Keystore backed by AWS KMS. It
+// lives in its own package, rather than the core gocbfieldcrypt package, so
+// that consumers who don't use AWS aren't forced to pull in the AWS SDK.
+package awskms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/couchbaselabs/gocbfieldcrypt"
+)
+
+// decrypter is the subset of *kms.Client's API this Keystore needs. It lets
+// tests substitute a fake KMS backend without a real AWS client.
+type decrypter interface {
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// Keystore is a gocbfieldcrypt.Keystore that resolves key ids through AWS
+// KMS. A key id is the base64 encoding of a KMS-encrypted ciphertext blob
+// (produced once, out of band, with kms:Encrypt against the raw key
+// material); GetKey calls kms:Decrypt to recover the plaintext key.
+type Keystore struct {
+	Client decrypter
+}
+
+// New returns a Keystore that resolves keys through client.
+func New(client *kms.Client) *Keystore {
+	return &Keystore{Client: client}
+}
+
+// GetKey implements the gocbfieldcrypt.Keystore interface.
+func (ks *Keystore) GetKey(keyId string) ([]byte, error) {
+	return ks.GetKeyVersion(keyId, "")
+}
+
+// GetKeyVersion implements the gocbfieldcrypt.Keystore interface. AWS KMS
+// ciphertext blobs are self-describing, so version is ignored.
+func (ks *Keystore) GetKeyVersion(keyId string, version string) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(keyId)
+	if err != nil {
+		return nil, &gocbfieldcrypt.CryptoError{
+			Type:    gocbfieldcrypt.CryptoProviderDecryptFailed,
+			Message: fmt.Sprintf("key id %q is not a base64-encoded KMS ciphertext blob", keyId),
+			Cause:   err,
+		}
+	}
+
+	out, err := ks.Client.Decrypt(context.Background(), &kms.DecryptInput{
+		CiphertextBlob: blob,
+	})
+	if err != nil {
+		return nil, &gocbfieldcrypt.CryptoError{
+			Type:    gocbfieldcrypt.CryptoProviderDecryptFailed,
+			Message: "failed to decrypt key via AWS KMS",
+			Cause:   err,
+		}
+	}
+	return out.Plaintext, nil
+}
+
+// Close implements the gocbfieldcrypt.Keystore interface. The AWS SDK
+// client holds no resources that need explicit cleanup.
+func (ks *Keystore) Close() error {
+	return nil
+}
@@ -0,0 +1,70 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package awskms
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/couchbaselabs/gocbfieldcrypt"
+)
+
+// fakeDecrypter stands in for AWS KMS, returning a fixed plaintext for a
+// known ciphertext blob without making a real call.
+type fakeDecrypter struct {
+	blobs map[string][]byte
+	err   error
+}
+
+func (f *fakeDecrypter) Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	plaintext, ok := f.blobs[string(params.CiphertextBlob)]
+	if !ok {
+		return nil, errors.New("unknown ciphertext blob")
+	}
+	return &kms.DecryptOutput{Plaintext: plaintext}, nil
+}
+
+func TestKeystoreGetKey(t *testing.T) {
+	blob := []byte("encrypted-blob")
+	ks := &Keystore{Client: &fakeDecrypter{blobs: map[string][]byte{
+		string(blob): []byte("the-plaintext-key"),
+	}}}
+
+	keyId := base64.StdEncoding.EncodeToString(blob)
+	key, err := ks.GetKey(keyId)
+	if err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+	if string(key) != "the-plaintext-key" {
+		t.Fatalf("Unexpected key: %q", key)
+	}
+}
+
+func TestKeystoreGetKeyInvalidKeyId(t *testing.T) {
+	ks := &Keystore{Client: &fakeDecrypter{}}
+
+	_, err := ks.GetKey("not valid base64!!")
+	if !gocbfieldcrypt.IsCryptoErrorType(err, gocbfieldcrypt.CryptoProviderDecryptFailed) {
+		t.Fatalf("Expected decrypt failed error, was: %v", err)
+	}
+}
+
+func TestKeystoreGetKeyDecryptError(t *testing.T) {
+	ks := &Keystore{Client: &fakeDecrypter{err: errors.New("kms unavailable")}}
+
+	_, err := ks.GetKey(base64.StdEncoding.EncodeToString([]byte("blob")))
+	if !gocbfieldcrypt.IsCryptoErrorType(err, gocbfieldcrypt.CryptoProviderDecryptFailed) {
+		t.Fatalf("Expected decrypt failed error, was: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import "strings"
+
+// cipherData is the envelope stored in place of a field's plaintext value,
+// under the `__crypt_<field>` key. It is shared with the other Couchbase
+// SDKs, so field names and their JSON representation must not change.
+//
+// Version distinguishes the envelope shape a provider should expect:
+// the zero value identifies the legacy encrypt-then-MAC envelope (Iv,
+// Ciphertext and Signature, no Nonce), used by the original AES-256-HMAC-
+// SHA256 and RSA-2048 providers. A non-zero Version identifies a newer
+// envelope shape, such as the AEAD envelope (Nonce and Ciphertext, no
+// Signature since the authentication tag is appended to the ciphertext),
+// the deterministic AES-SIV envelope (Ciphertext alone, the synthetic IV
+// prepended to it), or the HPKE envelope (Enc holding the KEM output
+// alongside Nonce and Ciphertext).
+type cipherData struct {
+	Version    int    `json:"ver,omitempty"`
+	Algorithm  string `json:"alg"`
+	KeyId      string `json:"kid"`
+	Iv         string `json:"iv,omitempty"`
+	Nonce      string `json:"nonce,omitempty"`
+	Enc        string `json:"enc,omitempty"`
+	Ciphertext string `json:"ciphertext"`
+	Signature  string `json:"sig,omitempty"`
+}
+
+// cbcryptTag is the parsed form of a `cbcrypt` struct tag, e.g.
+// `cbcrypt:"aes256,somekey,hmackey"`. A tag may carry additional clauses
+// after a semicolon, such as `;roles=admin,billing`, which
+// DecryptJsonStructContext enforces through a PolicyKeystore rather than
+// the provider itself; DecryptJsonStruct ignores it.
+type cbcryptTag struct {
+	Algorithm string
+	KeyIds    []string
+
+	// Roles lists the roles a principal must hold, per the Authorizer
+	// consulted by a PolicyKeystore, to decrypt this field. Empty if the
+	// tag carries no `roles=` clause.
+	Roles []string
+}
+
+func parseCbcryptTag(tag string) cbcryptTag {
+	clauses := strings.Split(tag, ";")
+
+	parts := strings.Split(clauses[0], ",")
+	t := cbcryptTag{Algorithm: parts[0]}
+	if len(parts) > 1 {
+		t.KeyIds = parts[1:]
+	}
+
+	for _, clause := range clauses[1:] {
+		name, value, ok := strings.Cut(clause, "=")
+		if !ok || name != "roles" {
+			continue
+		}
+		t.Roles = strings.Split(value, ",")
+	}
+
+	return t
+}
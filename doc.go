@@ -0,0 +1,13 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+// Package gocbfieldcrypt implements field-level encryption of JSON documents
+// for use with the Couchbase Go SDK. Encrypted fields are replaced with a
+// `__crypt_<field>` entry holding a cipherData envelope, in a format shared
+// with the field-level encryption implementations of the other Couchbase
+// SDKs.
+package gocbfieldcrypt
@@ -0,0 +1,95 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import "fmt"
+
+// CryptoErrorType identifies the class of failure behind a *CryptoError,
+// allowing callers to use IsCryptoErrorType rather than matching on
+// error strings.
+type CryptoErrorType int
+
+const (
+	// CryptoProviderNotFound indicates that no provider is registered for
+	// the algorithm named in a `cbcrypt` tag or cipherData envelope.
+	CryptoProviderNotFound CryptoErrorType = iota
+
+	// CryptoProviderMissingPublicKey indicates that a `cbcrypt` tag did
+	// not specify the key id needed to perform the encryption, or that a
+	// Keystore holds no key under the id it was asked for.
+	CryptoProviderMissingPublicKey
+
+	// CryptoProviderMissingPrivateKey indicates that a `cbcrypt` tag did
+	// not specify the second key id a provider needs (aes256's HMAC key,
+	// or hpke's private key).
+	CryptoProviderMissingPrivateKey
+
+	// CryptoProviderMissingSigningKey indicates that a `cbcrypt` tag did
+	// not specify the key id used to sign or verify an envelope.
+	CryptoProviderMissingSigningKey
+
+	// CryptoProviderKeySize indicates that a key returned by the Keystore
+	// is the wrong size for the algorithm that requested it.
+	CryptoProviderKeySize
+
+	// CryptoProviderEncryptFailed indicates that a provider failed to
+	// encrypt a field for a reason other than the ones above.
+	CryptoProviderEncryptFailed
+
+	// CryptoProviderDecryptFailed indicates that a provider failed to
+	// decrypt a field for a reason other than the ones below.
+	CryptoProviderDecryptFailed
+
+	// CryptoProviderSignatureMismatch indicates that an envelope's
+	// signature or authentication tag did not verify, meaning either the
+	// wrong key was used or the envelope was tampered with.
+	CryptoProviderSignatureMismatch
+
+	// CryptoProviderInvalidVersion indicates that a cipherData envelope's
+	// Version does not match the one its Algorithm's provider expects.
+	CryptoProviderInvalidVersion
+
+	// CryptoProviderAccessDenied indicates that a PolicyKeystore's
+	// Authorizer returned an error while checking the roles a `cbcrypt`
+	// tag's `roles=` clause requires. An Authorizer that simply refuses
+	// the caller produces no error: the field is left encrypted.
+	CryptoProviderAccessDenied
+)
+
+// CryptoError is returned by every encrypt/decrypt operation in this
+// package that fails. Use IsCryptoErrorType to check for a specific
+// failure rather than comparing errors directly.
+type CryptoError struct {
+	Type    CryptoErrorType
+	Message string
+	Cause   error
+}
+
+func (e *CryptoError) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s", e.Message, e.Cause)
+	}
+	return e.Message
+}
+
+func (e *CryptoError) Unwrap() error {
+	return e.Cause
+}
+
+func newCryptoError(t CryptoErrorType, message string, cause error) *CryptoError {
+	return &CryptoError{Type: t, Message: message, Cause: cause}
+}
+
+// IsCryptoErrorType returns whether err is a *CryptoError of type t.
+func IsCryptoErrorType(err error, t CryptoErrorType) bool {
+	cryptoErr, ok := err.(*CryptoError)
+	if !ok {
+		return false
+	}
+	return cryptoErr.Type == t
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+// Package gcpkms implements a gocbfieldcrypt.Keystore backed by Google
+// Cloud KMS. It lives in its own package, rather than the core
+// gocbfieldcrypt package, so that consumers who don't use GCP aren't forced
+// to pull in its SDK.
+package gcpkms
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	kms "cloud.google.com/go/kms/apiv1"
+	"cloud.google.com/go/kms/apiv1/kmspb"
+	"github.com/couchbaselabs/gocbfieldcrypt"
+	"github.com/googleapis/gax-go/v2"
+)
+
+// decrypter is the subset of *kms.KeyManagementClient's API this Keystore
+// needs. It lets tests substitute a fake KMS backend without a real GCP
+// client.
+type decrypter interface {
+	Decrypt(ctx context.Context, req *kmspb.DecryptRequest, opts ...gax.CallOption) (*kmspb.DecryptResponse, error)
+	Close() error
+}
+
+// Keystore is a gocbfieldcrypt.Keystore that resolves key ids through
+// Google Cloud KMS. A key id is the base64 encoding of a ciphertext blob
+// produced once, out of band, by encrypting the raw key material with
+// CryptoKey; GetKey calls Decrypt to recover the plaintext key.
+type Keystore struct {
+	Client    decrypter
+	CryptoKey string
+}
+
+// New returns a Keystore that decrypts key material with cryptoKey (a
+// fully-qualified KMS CryptoKey resource name) through client.
+func New(client *kms.KeyManagementClient, cryptoKey string) *Keystore {
+	return &Keystore{Client: client, CryptoKey: cryptoKey}
+}
+
+// GetKey implements the gocbfieldcrypt.Keystore interface.
+func (ks *Keystore) GetKey(keyId string) ([]byte, error) {
+	return ks.GetKeyVersion(keyId, "")
+}
+
+// GetKeyVersion implements the gocbfieldcrypt.Keystore interface. GCP KMS
+// ciphertext blobs are self-describing, so version is ignored.
+func (ks *Keystore) GetKeyVersion(keyId string, version string) ([]byte, error) {
+	blob, err := base64.StdEncoding.DecodeString(keyId)
+	if err != nil {
+		return nil, &gocbfieldcrypt.CryptoError{
+			Type:    gocbfieldcrypt.CryptoProviderDecryptFailed,
+			Message: fmt.Sprintf("key id %q is not a base64-encoded KMS ciphertext blob", keyId),
+			Cause:   err,
+		}
+	}
+
+	resp, err := ks.Client.Decrypt(context.Background(), &kmspb.DecryptRequest{
+		Name:       ks.CryptoKey,
+		Ciphertext: blob,
+	})
+	if err != nil {
+		return nil, &gocbfieldcrypt.CryptoError{
+			Type:    gocbfieldcrypt.CryptoProviderDecryptFailed,
+			Message: "failed to decrypt key via Google Cloud KMS",
+			Cause:   err,
+		}
+	}
+	return resp.Plaintext, nil
+}
+
+// Close implements the gocbfieldcrypt.Keystore interface, closing the
+// underlying gRPC connection to Cloud KMS.
+func (ks *Keystore) Close() error {
+	return ks.Client.Close()
+}
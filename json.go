@@ -0,0 +1,196 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// fieldPrefix is prepended to a field's JSON name to form the key its
+// cipherData envelope is stored under.
+const fieldPrefix = "__crypt_"
+
+func jsonFieldName(f reflect.StructField) string {
+	if tag, ok := f.Tag.Lookup("json"); ok {
+		name := strings.Split(tag, ",")[0]
+		if name != "" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+// EncryptJsonStruct encrypts every field of t tagged with `cbcrypt` within
+// the JSON document data, replacing each plaintext field with a
+// `__crypt_<field>` entry holding its cipherData envelope.
+func EncryptJsonStruct(data []byte, t reflect.Type, ks Keystore) ([]byte, error) {
+	return encryptJsonStruct(context.Background(), data, t, ks, StreamOptions{})
+}
+
+// EncryptJsonStructWithOptions behaves like EncryptJsonStruct, except a
+// field whose plaintext exceeds opts.Threshold is sealed with
+// EncryptJsonStreamField and stored as an attachment in opts.Attachments
+// instead of inline in the document. See StreamOptions.
+func EncryptJsonStructWithOptions(data []byte, t reflect.Type, ks Keystore, opts StreamOptions) ([]byte, error) {
+	return encryptJsonStruct(context.Background(), data, t, ks, opts)
+}
+
+// EncryptJsonStructContext is the ctx-taking counterpart of
+// DecryptJsonStructContext. Encryption never consults a `roles=` clause,
+// so ctx is not passed to ks or to any Authorizer, and the result is the
+// same as EncryptJsonStructWithOptions.
+func EncryptJsonStructContext(ctx context.Context, data []byte, t reflect.Type, ks Keystore, opts StreamOptions) ([]byte, error) {
+	return encryptJsonStruct(ctx, data, t, ks, opts)
+}
+
+func encryptJsonStruct(ctx context.Context, data []byte, t reflect.Type, ks Keystore, opts StreamOptions) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to unmarshal document", err)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("cbcrypt")
+		if !ok {
+			continue
+		}
+		cryptTag := parseCbcryptTag(tag)
+		name := jsonFieldName(field)
+
+		plaintext, ok := doc[name]
+		if !ok {
+			continue
+		}
+
+		var cd *cipherData
+		if opts.shouldStream(len(plaintext)) {
+			var err error
+			cd, err = opts.encryptStreamed(plaintext, cryptTag, ks)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			provider, err := providerByName(cryptTag.Algorithm)
+			if err != nil {
+				return nil, err
+			}
+			cd, err = provider.Encrypt(plaintext, cryptTag.KeyIds, ks)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		encoded, err := json.Marshal(cd)
+		if err != nil {
+			return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to marshal cipher data", err)
+		}
+
+		delete(doc, name)
+		doc[fieldPrefix+name] = encoded
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to marshal document", err)
+	}
+	return out, nil
+}
+
+// DecryptJsonStruct reverses EncryptJsonStruct, restoring the plaintext
+// value of every field of t tagged with `cbcrypt`. It never consults a
+// `roles=` clause; see DecryptJsonStructContext for that.
+func DecryptJsonStruct(data []byte, t reflect.Type, ks Keystore) ([]byte, error) {
+	return decryptJsonStruct(context.Background(), data, t, ks, StreamOptions{}, false)
+}
+
+// DecryptJsonStructWithOptions behaves like DecryptJsonStruct, except a
+// field whose envelope references an attachment is read back from
+// opts.Attachments and reassembled with DecryptJsonStreamField. Like
+// DecryptJsonStruct, it never consults a `roles=` clause.
+func DecryptJsonStructWithOptions(data []byte, t reflect.Type, ks Keystore, opts StreamOptions) ([]byte, error) {
+	return decryptJsonStruct(context.Background(), data, t, ks, opts, false)
+}
+
+// DecryptJsonStructContext behaves like DecryptJsonStructWithOptions, but
+// threads ctx down to ks and enforces policy: if ks is a PolicyKeystore and
+// a field's `cbcrypt` tag carries a `roles=` clause, its Authorizer is asked
+// whether ctx's caller holds one of those roles before the field is
+// decrypted. A field the caller isn't authorized for is left untouched,
+// still encrypted under its `__crypt_<field>` key, rather than failing the
+// whole document.
+func DecryptJsonStructContext(ctx context.Context, data []byte, t reflect.Type, ks Keystore, opts StreamOptions) ([]byte, error) {
+	return decryptJsonStruct(ctx, data, t, ks, opts, true)
+}
+
+func decryptJsonStruct(ctx context.Context, data []byte, t reflect.Type, ks Keystore, opts StreamOptions, enforcePolicy bool) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to unmarshal document", err)
+	}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("cbcrypt")
+		if !ok {
+			continue
+		}
+		cryptTag := parseCbcryptTag(tag)
+		name := jsonFieldName(field)
+		encName := fieldPrefix + name
+
+		raw, ok := doc[encName]
+		if !ok {
+			continue
+		}
+
+		allowed, err := checkRoles(ctx, cryptTag, ks, enforcePolicy)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			continue
+		}
+
+		var cd cipherData
+		if err := json.Unmarshal(raw, &cd); err != nil {
+			return nil, newCryptoError(CryptoProviderDecryptFailed, fmt.Sprintf("failed to unmarshal cipher data for %q", name), err)
+		}
+
+		var plaintext []byte
+		if cd.Version == streamEnvelopeVersion {
+			var err error
+			plaintext, err = opts.decryptStreamed(&cd, cryptTag.KeyIds, ks)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			provider, err := providerByName(cryptTag.Algorithm)
+			if err != nil {
+				return nil, err
+			}
+			plaintext, err = provider.Decrypt(&cd, cryptTag.KeyIds, ks)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		delete(doc, encName)
+		doc[name] = plaintext
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to marshal document", err)
+	}
+	return out, nil
+}
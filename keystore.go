@@ -0,0 +1,56 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import "fmt"
+
+// Keystore is consulted by crypto providers to look up the key material
+// named by a `cbcrypt` tag's key ids.
+type Keystore interface {
+	// GetKey returns the raw key bytes for the current version of keyId,
+	// or an error if no such key exists.
+	GetKey(keyId string) ([]byte, error)
+
+	// GetKeyVersion returns the raw key bytes for a specific historical
+	// version of keyId, as recorded in a cipherData envelope's KeyId
+	// (e.g. "mypublickey:3"). Keystores that don't support multiple
+	// versions may treat this the same as GetKey.
+	GetKeyVersion(keyId string, version string) ([]byte, error)
+
+	// Close releases any resources held by the Keystore, such as network
+	// connections to a remote key management service.
+	Close() error
+}
+
+// InsecureKeystore is a Keystore backed by an in-memory map of plaintext
+// keys. It is intended for use in tests and examples only; production
+// code should prefer a Keystore backed by a real key management system.
+type InsecureKeystore struct {
+	Keys map[string][]byte
+}
+
+// GetKey implements the Keystore interface.
+func (ks *InsecureKeystore) GetKey(keyId string) ([]byte, error) {
+	key, ok := ks.Keys[keyId]
+	if !ok {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, fmt.Sprintf("no such key: %q", keyId), nil)
+	}
+	return key, nil
+}
+
+// GetKeyVersion implements the Keystore interface. InsecureKeystore keeps
+// a single flat map of keys, so it ignores version and defers to GetKey.
+func (ks *InsecureKeystore) GetKeyVersion(keyId string, version string) ([]byte, error) {
+	return ks.GetKey(keyId)
+}
+
+// Close implements the Keystore interface. InsecureKeystore holds no
+// resources, so this is a no-op.
+func (ks *InsecureKeystore) Close() error {
+	return nil
+}
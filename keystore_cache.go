@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CachedKeystore wraps another Keystore with an in-process LRU cache, so
+// that repeatedly encrypting or decrypting fields that share a key doesn't
+// pay a network round trip to the backing Keystore for every field. Entries
+// expire after the ttl given to NewCachedKeystore and the cache evicts its
+// least recently used entry once it holds more than maxEntries keys.
+type CachedKeystore struct {
+	Keystore
+	ttl        time.Duration
+	maxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type cachedKeyEntry struct {
+	cacheKey string
+	key      []byte
+	expireAt time.Time
+}
+
+// NewCachedKeystore wraps ks with an LRU cache that holds up to maxEntries
+// keys, each valid for ttl before it is re-fetched from ks. If ks is a
+// VersionedKeystore, the returned Keystore is one too, so that rotation
+// (see keyversion.go) still stamps and resolves key versions through the
+// cache.
+func NewCachedKeystore(ks Keystore, maxEntries int, ttl time.Duration) Keystore {
+	base := &CachedKeystore{
+		Keystore:   ks,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		entries:    make(map[string]*list.Element),
+		order:      list.New(),
+	}
+	if vks, ok := ks.(VersionedKeystore); ok {
+		return &cachedVersionedKeystore{CachedKeystore: base, versioned: vks}
+	}
+	return base
+}
+
+// cachedVersionedKeystore extends CachedKeystore with VersionedKeystore's
+// CurrentKeyVersion, forwarded straight to the wrapped Keystore uncached.
+// It exists as a distinct type, rather than a method on CachedKeystore
+// itself, so that a CachedKeystore wrapping a plain Keystore does not
+// structurally satisfy VersionedKeystore: resolveKeyId (keyversion.go)
+// gates "id:version" suffix splitting on that type assertion, and a plain
+// Keystore's native key id format may itself contain colons.
+type cachedVersionedKeystore struct {
+	*CachedKeystore
+	versioned VersionedKeystore
+}
+
+// CurrentKeyVersion implements VersionedKeystore.
+func (ks *cachedVersionedKeystore) CurrentKeyVersion(keyId string) (string, error) {
+	return ks.versioned.CurrentKeyVersion(keyId)
+}
+
+// GetKey overrides CachedKeystore.GetKey to cache by the wrapped
+// VersionedKeystore's current version of keyId, rather than by keyId
+// alone. Otherwise a cached "current" key fetched before a rotation would
+// keep being served under the bare keyId after CurrentKeyVersion moved on,
+// mismatching the new version versionedKeyId stamps into the envelope.
+func (ks *cachedVersionedKeystore) GetKey(keyId string) ([]byte, error) {
+	version, err := ks.versioned.CurrentKeyVersion(keyId)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		return ks.CachedKeystore.GetKey(keyId)
+	}
+	return ks.CachedKeystore.GetKeyVersion(keyId, version)
+}
+
+// GetKey implements the Keystore interface.
+func (ks *CachedKeystore) GetKey(keyId string) ([]byte, error) {
+	return ks.getCached(keyId, func() ([]byte, error) {
+		return ks.Keystore.GetKey(keyId)
+	})
+}
+
+// GetKeyVersion implements the Keystore interface.
+func (ks *CachedKeystore) GetKeyVersion(keyId string, version string) ([]byte, error) {
+	return ks.getCached(keyId+"\x00"+version, func() ([]byte, error) {
+		return ks.Keystore.GetKeyVersion(keyId, version)
+	})
+}
+
+func (ks *CachedKeystore) getCached(cacheKey string, fetch func() ([]byte, error)) ([]byte, error) {
+	ks.mu.Lock()
+	if elem, ok := ks.entries[cacheKey]; ok {
+		entry := elem.Value.(*cachedKeyEntry)
+		if time.Now().Before(entry.expireAt) {
+			ks.order.MoveToFront(elem)
+			ks.mu.Unlock()
+			return entry.key, nil
+		}
+		ks.removeLocked(elem)
+	}
+	ks.mu.Unlock()
+
+	key, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	elem := ks.order.PushFront(&cachedKeyEntry{
+		cacheKey: cacheKey,
+		key:      key,
+		expireAt: time.Now().Add(ks.ttl),
+	})
+	ks.entries[cacheKey] = elem
+	for ks.maxEntries > 0 && ks.order.Len() > ks.maxEntries {
+		ks.removeLocked(ks.order.Back())
+	}
+	return key, nil
+}
+
+func (ks *CachedKeystore) removeLocked(elem *list.Element) {
+	entry := ks.order.Remove(elem).(*cachedKeyEntry)
+	delete(ks.entries, entry.cacheKey)
+}
+
+// Close implements the Keystore interface, dropping the cache and closing
+// the wrapped Keystore.
+func (ks *CachedKeystore) Close() error {
+	ks.mu.Lock()
+	ks.entries = make(map[string]*list.Element)
+	ks.order = list.New()
+	ks.mu.Unlock()
+	return ks.Keystore.Close()
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"testing"
+	"time"
+)
+
+// countingKeystore counts how many times GetKey is called for each keyId,
+// so tests can tell whether CachedKeystore actually avoided a round trip.
+type countingKeystore struct {
+	InsecureKeystore
+	calls map[string]int
+}
+
+func (ks *countingKeystore) GetKey(keyId string) ([]byte, error) {
+	if ks.calls == nil {
+		ks.calls = make(map[string]int)
+	}
+	ks.calls[keyId]++
+	return ks.InsecureKeystore.GetKey(keyId)
+}
+
+func TestCachedKeystoreHitsCache(t *testing.T) {
+	backing := &countingKeystore{InsecureKeystore: InsecureKeystore{Keys: map[string][]byte{"somekey": []byte("value")}}}
+	cached := NewCachedKeystore(backing, 10, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		key, err := cached.GetKey("somekey")
+		if err != nil {
+			t.Fatalf("Failed to get key: %s", err)
+		}
+		if string(key) != "value" {
+			t.Fatalf("Unexpected key: %q", key)
+		}
+	}
+
+	if backing.calls["somekey"] != 1 {
+		t.Fatalf("Expected exactly 1 call to the backing keystore, got %d", backing.calls["somekey"])
+	}
+}
+
+func TestCachedKeystoreExpiresEntries(t *testing.T) {
+	backing := &countingKeystore{InsecureKeystore: InsecureKeystore{Keys: map[string][]byte{"somekey": []byte("value")}}}
+	cached := NewCachedKeystore(backing, 10, -time.Second)
+
+	if _, err := cached.GetKey("somekey"); err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+	if _, err := cached.GetKey("somekey"); err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+
+	if backing.calls["somekey"] != 2 {
+		t.Fatalf("Expected an already-expired entry to be re-fetched, got %d calls", backing.calls["somekey"])
+	}
+}
+
+func TestCachedKeystoreEvictsLeastRecentlyUsed(t *testing.T) {
+	backing := &countingKeystore{InsecureKeystore: InsecureKeystore{Keys: map[string][]byte{
+		"a": []byte("a-value"),
+		"b": []byte("b-value"),
+		"c": []byte("c-value"),
+	}}}
+	cached := NewCachedKeystore(backing, 2, time.Minute)
+
+	if _, err := cached.GetKey("a"); err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+	if _, err := cached.GetKey("b"); err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+	if _, err := cached.GetKey("c"); err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+
+	if _, err := cached.GetKey("a"); err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+	if backing.calls["a"] != 2 {
+		t.Fatalf("Expected \"a\" to have been evicted by \"c\" and re-fetched, got %d calls", backing.calls["a"])
+	}
+}
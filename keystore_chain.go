@@ -0,0 +1,106 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+// KeystoreChain consults a list of Keystores in order, returning the first
+// successful result and falling through to the next Keystore when one
+// returns any error; if all fail, the last Keystore's error is returned.
+// This is useful when migrating between key
+// management systems, or when some keys live in one Keystore and others in
+// another.
+type KeystoreChain struct {
+	Keystores []Keystore
+}
+
+// NewKeystoreChain returns a KeystoreChain that consults ks in order. If
+// any of ks is a VersionedKeystore, the returned Keystore is one too, so
+// that rotation (see keyversion.go) still stamps and resolves key versions
+// through the chain.
+func NewKeystoreChain(ks ...Keystore) Keystore {
+	base := &KeystoreChain{Keystores: ks}
+	for _, k := range ks {
+		if _, ok := k.(VersionedKeystore); ok {
+			return &versionedKeystoreChain{KeystoreChain: base}
+		}
+	}
+	return base
+}
+
+// versionedKeystoreChain extends KeystoreChain with VersionedKeystore's
+// CurrentKeyVersion, tried against each chained Keystore that is itself a
+// VersionedKeystore, in order. It exists as a distinct type, rather than a
+// method on KeystoreChain itself, so that a chain of entirely plain
+// Keystores does not structurally satisfy VersionedKeystore: resolveKeyId
+// (keyversion.go) gates "id:version" suffix splitting on that type
+// assertion, and a plain Keystore's native key id format may itself
+// contain colons.
+type versionedKeystoreChain struct {
+	*KeystoreChain
+}
+
+// CurrentKeyVersion implements VersionedKeystore.
+func (c *versionedKeystoreChain) CurrentKeyVersion(keyId string) (string, error) {
+	var lastErr error
+	for _, ks := range c.Keystores {
+		vks, ok := ks.(VersionedKeystore)
+		if !ok {
+			continue
+		}
+		version, err := vks.CurrentKeyVersion(keyId)
+		if err == nil {
+			return version, nil
+		}
+		lastErr = err
+	}
+	if lastErr == nil {
+		lastErr = newCryptoError(CryptoProviderMissingPublicKey, "keystore chain has no versioned keystores configured", nil)
+	}
+	return "", lastErr
+}
+
+// GetKey implements the Keystore interface.
+func (c *KeystoreChain) GetKey(keyId string) ([]byte, error) {
+	return c.try(func(ks Keystore) ([]byte, error) {
+		return ks.GetKey(keyId)
+	})
+}
+
+// GetKeyVersion implements the Keystore interface.
+func (c *KeystoreChain) GetKeyVersion(keyId string, version string) ([]byte, error) {
+	return c.try(func(ks Keystore) ([]byte, error) {
+		return ks.GetKeyVersion(keyId, version)
+	})
+}
+
+func (c *KeystoreChain) try(fetch func(ks Keystore) ([]byte, error)) ([]byte, error) {
+	if len(c.Keystores) == 0 {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, "keystore chain has no keystores configured", nil)
+	}
+
+	var lastErr error
+	for _, ks := range c.Keystores {
+		key, err := fetch(ks)
+		if err == nil {
+			return key, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// Close closes every Keystore in the chain, returning the first error
+// encountered, if any.
+func (c *KeystoreChain) Close() error {
+	var firstErr error
+	for _, ks := range c.Keystores {
+		if err := ks.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
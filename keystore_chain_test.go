@@ -0,0 +1,50 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import "testing"
+
+func TestKeystoreChainFallsThrough(t *testing.T) {
+	primary := &InsecureKeystore{Keys: map[string][]byte{"newkey": []byte("new-value")}}
+	secondary := &InsecureKeystore{Keys: map[string][]byte{"oldkey": []byte("old-value")}}
+	chain := NewKeystoreChain(primary, secondary)
+
+	key, err := chain.GetKey("newkey")
+	if err != nil {
+		t.Fatalf("Failed to get key from primary: %s", err)
+	}
+	if string(key) != "new-value" {
+		t.Fatalf("Unexpected key: %q", key)
+	}
+
+	key, err = chain.GetKey("oldkey")
+	if err != nil {
+		t.Fatalf("Failed to get key from secondary: %s", err)
+	}
+	if string(key) != "old-value" {
+		t.Fatalf("Unexpected key: %q", key)
+	}
+}
+
+func TestKeystoreChainNoMatch(t *testing.T) {
+	chain := NewKeystoreChain(&InsecureKeystore{Keys: map[string][]byte{}}, &InsecureKeystore{Keys: map[string][]byte{}})
+
+	_, err := chain.GetKey("missing")
+	if !IsCryptoErrorType(err, CryptoProviderMissingPublicKey) {
+		t.Fatalf("Expected the last keystore's error to be returned, was: %v", err)
+	}
+}
+
+func TestKeystoreChainEmpty(t *testing.T) {
+	chain := NewKeystoreChain()
+
+	_, err := chain.GetKey("anykey")
+	if err == nil {
+		t.Fatalf("Expected an error for an empty chain")
+	}
+}
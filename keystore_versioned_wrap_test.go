@@ -0,0 +1,123 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// rotateThroughVersionedWrapper runs the same rotation scenario as
+// TestRotateJsonStructReencryptsUnderCurrentVersion, but through ks rather
+// than the raw versionedTestKeystore, so CachedKeystore/KeystoreChain can
+// be tested as the VersionedKeystore rotation actually consults.
+func rotateThroughVersionedWrapper(t *testing.T, backing *versionedTestKeystore, ks Keystore) {
+	t.Helper()
+
+	if _, ok := ks.(VersionedKeystore); !ok {
+		t.Fatalf("Expected the wrapper to implement VersionedKeystore")
+	}
+
+	testObj := rotateTestStruct{CryptString: "World"}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	encBytes, err := EncryptJsonStruct(bytes, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+
+	backing.current["mykey"] = "2"
+
+	rotated, changed, err := RotateJsonStruct(encBytes, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to rotate: %s", err)
+	}
+	if !changed {
+		t.Fatalf("Expected rotation to report a change after the key version moved")
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(rotated, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal rotated document: %s", err)
+	}
+	var cd cipherData
+	if err := json.Unmarshal(doc["__crypt_CryptString"], &cd); err != nil {
+		t.Fatalf("Failed to unmarshal cipher data: %s", err)
+	}
+	if cd.KeyId != "mykey:2" {
+		t.Fatalf("Expected rotated envelope to be stamped with the new key version, got KeyId %q", cd.KeyId)
+	}
+
+	decBytes, err := DecryptJsonStruct(rotated, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to decrypt rotated document: %s", err)
+	}
+	var decObj rotateTestStruct
+	if err := json.Unmarshal(decBytes, &decObj); err != nil {
+		t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+	}
+	if decObj != testObj {
+		t.Fatalf("Decrypted document did not match original, got %+v", decObj)
+	}
+}
+
+func TestRotateJsonStructThroughCachedKeystore(t *testing.T) {
+	keyV1 := make([]byte, 32)
+	keyV2 := make([]byte, 32)
+	for i := range keyV2 {
+		keyV2[i] = byte(i + 1)
+	}
+
+	backing := &versionedTestKeystore{
+		keys:    map[string]map[string][]byte{"mykey": {"1": keyV1, "2": keyV2}},
+		current: map[string]string{"mykey": "1"},
+	}
+
+	rotateThroughVersionedWrapper(t, backing, NewCachedKeystore(backing, 10, time.Minute))
+}
+
+func TestRotateJsonStructThroughKeystoreChain(t *testing.T) {
+	keyV1 := make([]byte, 32)
+	keyV2 := make([]byte, 32)
+	for i := range keyV2 {
+		keyV2[i] = byte(i + 1)
+	}
+
+	backing := &versionedTestKeystore{
+		keys:    map[string]map[string][]byte{"mykey": {"1": keyV1, "2": keyV2}},
+		current: map[string]string{"mykey": "1"},
+	}
+
+	rotateThroughVersionedWrapper(t, backing, NewKeystoreChain(backing))
+}
+
+// TestCachedKeystoreWrappingPlainKeystoreIsNotVersioned guards against the
+// cache unconditionally satisfying VersionedKeystore: wrapping a plain
+// Keystore (such as vaulttransit's, whose native key ids may themselves
+// contain colons) must not turn it into one, or resolveKeyId would start
+// splitting those ids on ":" and corrupt them.
+func TestCachedKeystoreWrappingPlainKeystoreIsNotVersioned(t *testing.T) {
+	cached := NewCachedKeystore(&InsecureKeystore{Keys: map[string][]byte{"somekey": []byte("value")}}, 10, time.Minute)
+	if _, ok := cached.(VersionedKeystore); ok {
+		t.Fatalf("Expected a CachedKeystore wrapping a plain Keystore not to implement VersionedKeystore")
+	}
+}
+
+// TestKeystoreChainOfPlainKeystoresIsNotVersioned is the KeystoreChain
+// analogue of TestCachedKeystoreWrappingPlainKeystoreIsNotVersioned.
+func TestKeystoreChainOfPlainKeystoresIsNotVersioned(t *testing.T) {
+	chain := NewKeystoreChain(&InsecureKeystore{Keys: map[string][]byte{}}, &InsecureKeystore{Keys: map[string][]byte{}})
+	if _, ok := chain.(VersionedKeystore); ok {
+		t.Fatalf("Expected a KeystoreChain of plain Keystores not to implement VersionedKeystore")
+	}
+}
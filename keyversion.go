@@ -0,0 +1,72 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import "strings"
+
+// VersionedKeystore is implemented by Keystores that track more than one
+// version of a key, such as those backed by a KMS with key rotation
+// enabled. Providers use it to stamp the current key version into a
+// cipherData envelope's KeyId at encrypt time (e.g. "mypublickey:3"), so
+// that a later rotation can tell which version was used to encrypt a
+// given field and decrypt can always locate that exact version, even
+// after the Keystore's current version has moved on.
+//
+// A Keystore that doesn't implement VersionedKeystore is treated as
+// having a single, unversioned key per id.
+type VersionedKeystore interface {
+	Keystore
+
+	// CurrentKeyVersion returns the version identifier of the current
+	// key material for keyId (e.g. "3").
+	CurrentKeyVersion(keyId string) (string, error)
+}
+
+// versionedKeyId stamps ks's current version of keyId into the KeyId
+// recorded in a cipherData envelope, if ks is a VersionedKeystore.
+func versionedKeyId(keyId string, ks Keystore) (string, error) {
+	vks, ok := ks.(VersionedKeystore)
+	if !ok {
+		return keyId, nil
+	}
+	version, err := vks.CurrentKeyVersion(keyId)
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		return keyId, nil
+	}
+	return keyId + ":" + version, nil
+}
+
+// resolveKeyId fetches the key material named by a cipherData envelope's
+// KeyId, honouring any "id:version" suffix stamped in by versionedKeyId
+// against a VersionedKeystore. The suffix is only ever split off for a
+// VersionedKeystore, since only versionedKeyId ever stamps one in: a plain
+// Keystore's native key id format (e.g. vaulttransit's "vault:v1:..."
+// ciphertexts) may itself contain colons, and splitting those apart would
+// send mangled input to GetKeyVersion.
+func resolveKeyId(keyId string, ks Keystore) ([]byte, error) {
+	vks, ok := ks.(VersionedKeystore)
+	if !ok {
+		return ks.GetKey(keyId)
+	}
+	id, version := splitVersionedKeyId(keyId)
+	if version == "" {
+		return vks.GetKey(id)
+	}
+	return vks.GetKeyVersion(id, version)
+}
+
+func splitVersionedKeyId(keyId string) (id string, version string) {
+	idx := strings.LastIndex(keyId, ":")
+	if idx < 0 {
+		return keyId, ""
+	}
+	return keyId[:idx], keyId[idx+1:]
+}
@@ -0,0 +1,87 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import "context"
+
+// Authorizer decides whether the principal identified by ctx may use a key
+// named by a `cbcrypt` tag's `roles=` clause. Implementations typically read
+// the principal's roles from ctx (e.g. set by request-handling middleware)
+// and compare them against required.
+type Authorizer interface {
+	// Authorize reports whether the caller in ctx holds at least one of
+	// the roles in required for keyId. A nil error with a false result
+	// means the caller was recognised but lacks the necessary role; a
+	// non-nil error means the check itself failed.
+	Authorize(ctx context.Context, keyId string, required []string) (bool, error)
+}
+
+// roleAuthorizer is implemented by a Keystore, such as PolicyKeystore, that
+// enforces a `cbcrypt` tag's `roles=` clause before a field may be
+// decrypted. DecryptJsonStructContext consults it directly, ahead of the
+// provider, so an unauthorized field can be left encrypted instead of
+// failing the whole document.
+type roleAuthorizer interface {
+	authorizeRoles(ctx context.Context, keyId string, required []string) (bool, error)
+}
+
+// PolicyKeystore wraps a Keystore with an Authorizer that gates access to
+// any field whose `cbcrypt` tag carries a `roles=` clause, e.g.
+// `cbcrypt:"aes256,dek,hmac;roles=admin,billing"`. It changes no behaviour
+// of its own for tags without such a clause; GetKey and GetKeyVersion are
+// forwarded unchanged.
+//
+// PolicyKeystore only has an effect through DecryptJsonStructContext:
+// DecryptJsonStruct and DecryptJsonStructWithOptions ignore a tag's roles
+// clause entirely, since neither has a caller-supplied ctx to hand the
+// Authorizer. Encryption never consults a roles clause, regardless of
+// entry point.
+type PolicyKeystore struct {
+	Keystore
+
+	// Authorizer is consulted for every tagged field that carries a
+	// `roles=` clause.
+	Authorizer Authorizer
+}
+
+// NewPolicyKeystore returns a PolicyKeystore wrapping ks, consulting authz
+// for any field whose `cbcrypt` tag carries a `roles=` clause.
+func NewPolicyKeystore(ks Keystore, authz Authorizer) *PolicyKeystore {
+	return &PolicyKeystore{Keystore: ks, Authorizer: authz}
+}
+
+// authorizeRoles implements roleAuthorizer.
+func (ks *PolicyKeystore) authorizeRoles(ctx context.Context, keyId string, required []string) (bool, error) {
+	return ks.Authorizer.Authorize(ctx, keyId, required)
+}
+
+// checkRoles reports whether cryptTag may be decrypted under ks, given ctx.
+// enforce is false for DecryptJsonStruct/DecryptJsonStructWithOptions,
+// which have no caller-supplied ctx to hand the Authorizer and so never
+// enforce a `roles=` clause; it is true only for DecryptJsonStructContext.
+// Tags with no `roles=` clause, and Keystores that aren't a PolicyKeystore,
+// are always allowed regardless: the policy clause only has teeth once a
+// PolicyKeystore is actually in use.
+func checkRoles(ctx context.Context, cryptTag cbcryptTag, ks Keystore, enforce bool) (bool, error) {
+	if !enforce || len(cryptTag.Roles) == 0 {
+		return true, nil
+	}
+	authz, ok := ks.(roleAuthorizer)
+	if !ok {
+		return true, nil
+	}
+	keyId := ""
+	if len(cryptTag.KeyIds) > 0 {
+		keyId = cryptTag.KeyIds[0]
+	}
+	allowed, err := authz.authorizeRoles(ctx, keyId, cryptTag.Roles)
+	if err != nil {
+		return false, newCryptoError(CryptoProviderAccessDenied, "authorizer failed", err)
+	}
+	return allowed, nil
+}
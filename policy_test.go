@@ -0,0 +1,169 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testPolicyStruct struct {
+	AdminOnly   string `cbcrypt:"aes256,somekey,hmackey;roles=admin"`
+	BillingOnly string `cbcrypt:"aes256,somekey,hmackey;roles=billing"`
+	Open        string `cbcrypt:"aes256,somekey,hmackey"`
+}
+
+// testRoleAuthorizer grants access to whatever roles are in allowed,
+// regardless of keyId or ctx.
+type testRoleAuthorizer struct {
+	allowed map[string]bool
+	err     error
+}
+
+func (a *testRoleAuthorizer) Authorize(ctx context.Context, keyId string, required []string) (bool, error) {
+	if a.err != nil {
+		return false, a.err
+	}
+	for _, role := range required {
+		if a.allowed[role] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func newPolicyTestFixture(t *testing.T) ([]byte, reflect.Type, *InsecureKeystore) {
+	t.Helper()
+	testKey, _ := hex.DecodeString("1234567890123456123456789012345612345678901234561234567890123456")
+	keyStore := &InsecureKeystore{
+		Keys: map[string][]byte{
+			"somekey": testKey,
+			"hmackey": testKey,
+		},
+	}
+
+	testObj := testPolicyStruct{
+		AdminOnly:   "top secret",
+		BillingOnly: "invoice data",
+		Open:        "anyone can read this",
+	}
+
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	encBytes, err := EncryptJsonStruct(bytes, reflect.TypeOf(testObj), keyStore)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+	return encBytes, reflect.TypeOf(testObj), keyStore
+}
+
+// TestDecryptJsonStructContextPartial checks that a field whose `roles=`
+// clause the Authorizer refuses is left encrypted, while fields the caller
+// is authorized for (or that carry no roles clause at all) decrypt as
+// usual.
+func TestDecryptJsonStructContextPartial(t *testing.T) {
+	encBytes, structType, keyStore := newPolicyTestFixture(t)
+
+	policyStore := NewPolicyKeystore(keyStore, &testRoleAuthorizer{allowed: map[string]bool{"billing": true}})
+
+	decBytes, err := DecryptJsonStructContext(context.Background(), encBytes, structType, policyStore, StreamOptions{})
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %s", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(decBytes, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+	}
+
+	if _, ok := doc["AdminOnly"]; ok {
+		t.Fatalf("Expected AdminOnly to remain encrypted")
+	}
+	if _, ok := doc["__crypt_AdminOnly"]; !ok {
+		t.Fatalf("Expected __crypt_AdminOnly envelope to remain in the document")
+	}
+
+	var billing, open string
+	if err := json.Unmarshal(doc["BillingOnly"], &billing); err != nil {
+		t.Fatalf("Expected BillingOnly to be decrypted: %s", err)
+	}
+	if billing != "invoice data" {
+		t.Fatalf("Unexpected BillingOnly value: %q", billing)
+	}
+	if err := json.Unmarshal(doc["Open"], &open); err != nil {
+		t.Fatalf("Expected Open to be decrypted: %s", err)
+	}
+	if open != "anyone can read this" {
+		t.Fatalf("Unexpected Open value: %q", open)
+	}
+}
+
+// TestDecryptJsonStructIgnoresRolesWithoutPolicyKeystore checks that a
+// `roles=` clause has no effect unless the Keystore in use is a
+// PolicyKeystore: even DecryptJsonStructContext, which enforces roles,
+// decrypts every field when given a plain Keystore.
+func TestDecryptJsonStructIgnoresRolesWithoutPolicyKeystore(t *testing.T) {
+	encBytes, structType, keyStore := newPolicyTestFixture(t)
+
+	decBytes, err := DecryptJsonStructContext(context.Background(), encBytes, structType, keyStore, StreamOptions{})
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %s", err)
+	}
+
+	var decData testPolicyStruct
+	if err := json.Unmarshal(decBytes, &decData); err != nil {
+		t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+	}
+	if decData.AdminOnly != "top secret" || decData.BillingOnly != "invoice data" {
+		t.Fatalf("Expected all fields to be decrypted, got %+v", decData)
+	}
+}
+
+// TestDecryptJsonStructBypassesPolicyKeystore checks that plain
+// DecryptJsonStruct decrypts every field even when ks is a PolicyKeystore
+// that would deny every role, since it has no ctx to hand the Authorizer
+// and so never enforces a `roles=` clause (see DecryptJsonStructContext
+// for the entry point that does).
+func TestDecryptJsonStructBypassesPolicyKeystore(t *testing.T) {
+	encBytes, structType, keyStore := newPolicyTestFixture(t)
+
+	policyStore := NewPolicyKeystore(keyStore, &testRoleAuthorizer{allowed: map[string]bool{}})
+
+	decBytes, err := DecryptJsonStruct(encBytes, structType, policyStore)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %s", err)
+	}
+
+	var decData testPolicyStruct
+	if err := json.Unmarshal(decBytes, &decData); err != nil {
+		t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+	}
+	if decData.AdminOnly != "top secret" || decData.BillingOnly != "invoice data" {
+		t.Fatalf("Expected all fields to be decrypted despite a deny-all Authorizer, got %+v", decData)
+	}
+}
+
+// TestDecryptJsonStructContextAuthorizerError checks that an Authorizer
+// failure fails the whole document rather than being treated as a denial.
+func TestDecryptJsonStructContextAuthorizerError(t *testing.T) {
+	encBytes, structType, keyStore := newPolicyTestFixture(t)
+
+	policyStore := NewPolicyKeystore(keyStore, &testRoleAuthorizer{err: errors.New("authorizer unreachable")})
+
+	_, err := DecryptJsonStructContext(context.Background(), encBytes, structType, policyStore, StreamOptions{})
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderAccessDenied) {
+		t.Fatalf("Expected access denied error, was: %v", err)
+	}
+}
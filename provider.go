@@ -0,0 +1,45 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"fmt"
+	"sync"
+)
+
+// cryptoProvider performs the encrypt/decrypt work for a single `cbcrypt`
+// algorithm name. keyIds is the list of key ids parsed from the tag, in
+// the order they appear after the algorithm name.
+type cryptoProvider interface {
+	Encrypt(plaintext []byte, keyIds []string, ks Keystore) (*cipherData, error)
+	Decrypt(data *cipherData, keyIds []string, ks Keystore) ([]byte, error)
+}
+
+var (
+	providersMu sync.RWMutex
+	providers   = make(map[string]cryptoProvider)
+)
+
+// registerProvider makes a cryptoProvider available under the given
+// `cbcrypt` algorithm name. It is called from the init() of each provider
+// implementation.
+func registerProvider(name string, p cryptoProvider) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers[name] = p
+}
+
+func providerByName(name string) (cryptoProvider, error) {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	p, ok := providers[name]
+	if !ok {
+		return nil, newCryptoError(CryptoProviderNotFound, fmt.Sprintf("no crypto provider registered for %q", name), nil)
+	}
+	return p, nil
+}
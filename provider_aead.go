@@ -0,0 +1,148 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+func init() {
+	registerProvider("aes256-gcm", &aeadProvider{name: "aes256-gcm", algName: "AES-256-GCM", newAEAD: newAESGCM})
+	registerProvider("chacha20-poly1305", &aeadProvider{name: "chacha20-poly1305", algName: "ChaCha20-Poly1305", newAEAD: chacha20poly1305.New})
+	registerProvider("xchacha20-poly1305", &aeadProvider{name: "xchacha20-poly1305", algName: "XChaCha20-Poly1305", newAEAD: chacha20poly1305.NewX})
+}
+
+// aeadEnvelopeVersion identifies the AEAD cipherData envelope shape: a
+// random nonce plus a ciphertext with the authentication tag appended,
+// and no separate Signature field.
+const aeadEnvelopeVersion = 1
+
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aeadProvider implements the aes256-gcm, chacha20-poly1305 and
+// xchacha20-poly1305 cbcrypt algorithms. Each takes a single key id naming
+// a 256-bit AEAD key; the KeyId and Algorithm are bound in as associated
+// data so that an envelope with either changed fails to open.
+type aeadProvider struct {
+	name    string
+	algName string
+	newAEAD func(key []byte) (cipher.AEAD, error)
+}
+
+// encryptAEAD resolves keyIds[0] to an AEAD cipher ready to seal, along
+// with the (possibly version-stamped) key id to record in an envelope.
+// It is shared by Encrypt and the streaming path in stream.go.
+func (p *aeadProvider) encryptAEAD(keyIds []string, ks Keystore) (cipher.AEAD, string, error) {
+	if len(keyIds) == 0 {
+		return nil, "", newCryptoError(CryptoProviderMissingPublicKey, fmt.Sprintf("%s requires an encryption key id", p.name), nil)
+	}
+
+	key, err := ks.GetKey(keyIds[0])
+	if err != nil {
+		return nil, "", err
+	}
+	aead, err := p.newAEAD(key)
+	if err != nil {
+		return nil, "", newCryptoError(CryptoProviderKeySize, fmt.Sprintf("%s requires a 32 byte key", p.name), err)
+	}
+
+	keyId, err := versionedKeyId(keyIds[0], ks)
+	if err != nil {
+		return nil, "", err
+	}
+	return aead, keyId, nil
+}
+
+// decryptAEAD resolves the exact key version named by a stored keyId (as
+// opposed to the Keystore's current version) to an AEAD cipher ready to
+// open. It is shared by Decrypt and the streaming path in stream.go.
+func (p *aeadProvider) decryptAEAD(keyId string, ks Keystore) (cipher.AEAD, error) {
+	key, err := resolveKeyId(keyId, ks)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := p.newAEAD(key)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderKeySize, fmt.Sprintf("%s requires a 32 byte key", p.name), err)
+	}
+	return aead, nil
+}
+
+func (p *aeadProvider) Encrypt(plaintext []byte, keyIds []string, ks Keystore) (*cipherData, error) {
+	aead, keyId, err := p.encryptAEAD(keyIds, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to generate nonce", err)
+	}
+
+	ciphertext := aead.Seal(nil, nonce, plaintext, associatedData(p.algName, keyId))
+
+	return &cipherData{
+		Version:    aeadEnvelopeVersion,
+		Algorithm:  p.algName,
+		KeyId:      keyId,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+func (p *aeadProvider) Decrypt(data *cipherData, keyIds []string, ks Keystore) ([]byte, error) {
+	if data.Version != aeadEnvelopeVersion {
+		return nil, newCryptoError(CryptoProviderInvalidVersion, fmt.Sprintf("%s does not support envelope version %d", p.name, data.Version), nil)
+	}
+
+	keyId := data.KeyId
+	if keyId == "" {
+		if len(keyIds) == 0 {
+			return nil, newCryptoError(CryptoProviderMissingPublicKey, fmt.Sprintf("%s requires an encryption key id", p.name), nil)
+		}
+		keyId = keyIds[0]
+	}
+
+	aead, err := p.decryptAEAD(keyId, ks)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(data.Nonce)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode nonce", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(data.Ciphertext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode ciphertext", err)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associatedData(data.Algorithm, keyId))
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderSignatureMismatch, "failed to open aead envelope", err)
+	}
+	return plaintext, nil
+}
+
+// associatedData binds the key id and algorithm name into the AEAD tag so
+// that swapping either in a stored envelope is detected on decrypt.
+func associatedData(algorithm, keyId string) []byte {
+	return []byte(keyId + ":" + algorithm)
+}
@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestJsonStructAEAD(t *testing.T) {
+	testKey, _ := hex.DecodeString("1234567890123456123456789012345612345678901234561234567890123456")
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"aeadkey": testKey}}
+
+	for _, algorithm := range []string{"aes256-gcm", "chacha20-poly1305", "xchacha20-poly1305"} {
+		t.Run(algorithm, func(t *testing.T) {
+			testCryptStruct := struct {
+				CryptString string `cbcrypt:"algorithm,aeadkey"`
+			}{CryptString: "World"}
+
+			field := reflect.StructOf([]reflect.StructField{
+				{
+					Name: "CryptString",
+					Type: reflect.TypeOf(""),
+					Tag:  reflect.StructTag(`cbcrypt:"` + algorithm + `,aeadkey"`),
+				},
+			})
+
+			testObj := reflect.New(field).Elem()
+			testObj.Field(0).SetString(testCryptStruct.CryptString)
+
+			bytes, err := json.Marshal(testObj.Addr().Interface())
+			if err != nil {
+				t.Fatalf("Failed to marshal: %s", err)
+			}
+
+			encBytes, err := EncryptJsonStruct(bytes, field, keyStore)
+			if err != nil {
+				t.Fatalf("Failed to encrypt: %s", err)
+			}
+
+			decBytes, err := DecryptJsonStruct(encBytes, field, keyStore)
+			if err != nil {
+				t.Fatalf("Failed to decrypt: %s", err)
+			}
+
+			decObj := reflect.New(field)
+			if err := json.Unmarshal(decBytes, decObj.Interface()); err != nil {
+				t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+			}
+			if decObj.Elem().Field(0).String() != "World" {
+				t.Fatalf("Decrypted value did not match original, got %q", decObj.Elem().Field(0).String())
+			}
+		})
+	}
+}
+
+func TestAEADTamperedCiphertextFailsToDecrypt(t *testing.T) {
+	testKey, _ := hex.DecodeString("1234567890123456123456789012345612345678901234561234567890123456")
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"aeadkey": testKey}}
+
+	type testStruct struct {
+		CryptString string `cbcrypt:"aes256-gcm,aeadkey"`
+	}
+	testObj := testStruct{CryptString: "World"}
+
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+	encBytes, err := EncryptJsonStruct(bytes, reflect.TypeOf(testObj), keyStore)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(encBytes, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal document: %s", err)
+	}
+	var cd cipherData
+	if err := json.Unmarshal(doc["__crypt_CryptString"], &cd); err != nil {
+		t.Fatalf("Failed to unmarshal cipher data: %s", err)
+	}
+	cd.Ciphertext = cd.Ciphertext[:len(cd.Ciphertext)-4] + "AAAA"
+	tampered, err := json.Marshal(&cd)
+	if err != nil {
+		t.Fatalf("Failed to marshal tampered cipher data: %s", err)
+	}
+	doc["__crypt_CryptString"] = tampered
+	tamperedBytes, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal tampered document: %s", err)
+	}
+
+	_, err = DecryptJsonStruct(tamperedBytes, reflect.TypeOf(testObj), keyStore)
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderSignatureMismatch) {
+		t.Fatalf("Expected signature mismatch error, was: %v", err)
+	}
+}
+
+func TestAEADKeySizeError(t *testing.T) {
+	testKey, _ := hex.DecodeString("1234")
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"aeadkey": testKey}}
+
+	type testStruct struct {
+		CryptString string `cbcrypt:"aes256-gcm,aeadkey"`
+	}
+	testObj := testStruct{CryptString: "World"}
+
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	_, err = EncryptJsonStruct(bytes, reflect.TypeOf(testObj), keyStore)
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderKeySize) {
+		t.Fatalf("Expected key size error, was: %v", err)
+	}
+}
+
+func TestAEADMissingKeyId(t *testing.T) {
+	type testStruct struct {
+		CryptString string `cbcrypt:"aes256-gcm"`
+	}
+	testObj := testStruct{CryptString: "World"}
+
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	_, err = EncryptJsonStruct(bytes, reflect.TypeOf(testObj), &InsecureKeystore{})
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderMissingPublicKey) {
+		t.Fatalf("Expected missing public key error, was: %v", err)
+	}
+}
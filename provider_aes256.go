@@ -0,0 +1,168 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+)
+
+func init() {
+	registerProvider("aes256", &aes256Provider{})
+}
+
+// algNameAES256HMAC is the Algorithm string other Couchbase SDKs use for
+// the legacy AES-256-CBC encrypt-then-MAC envelope.
+const algNameAES256HMAC = "AES-256-HMAC-SHA256"
+
+// aes256Provider implements the legacy "aes256" cbcrypt algorithm: AES-256
+// in CBC mode with PKCS#7 padding, authenticated with HMAC-SHA256 over the
+// key id, algorithm name and base64-encoded IV and ciphertext (matching the
+// other Couchbase SDKs' envelope, for cross-SDK read compatibility). The
+// first key id is the AES key, the second is the HMAC key.
+type aes256Provider struct{}
+
+func (p *aes256Provider) Encrypt(plaintext []byte, keyIds []string, ks Keystore) (*cipherData, error) {
+	if len(keyIds) == 0 {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, "aes256 requires an encryption key id", nil)
+	}
+	if len(keyIds) < 2 {
+		return nil, newCryptoError(CryptoProviderMissingPrivateKey, "aes256 requires a signing key id", nil)
+	}
+
+	encKey, err := ks.GetKey(keyIds[0])
+	if err != nil {
+		return nil, err
+	}
+	if len(encKey) != 32 {
+		return nil, newCryptoError(CryptoProviderKeySize, fmt.Sprintf("aes256 requires a 32 byte key, got %d bytes", len(encKey)), nil)
+	}
+	hmacKey, err := ks.GetKey(keyIds[1])
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to create aes cipher", err)
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to generate iv", err)
+	}
+
+	padded := pkcs7Pad(plaintext, aes.BlockSize)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+
+	keyId, err := versionedKeyId(keyIds[0], ks)
+	if err != nil {
+		return nil, err
+	}
+
+	ivStr := base64.StdEncoding.EncodeToString(iv)
+	ciphertextStr := base64.StdEncoding.EncodeToString(ciphertext)
+	sig := aes256Signature(hmacKey, keyId, algNameAES256HMAC, ivStr, ciphertextStr)
+
+	return &cipherData{
+		Algorithm:  algNameAES256HMAC,
+		KeyId:      keyId,
+		Iv:         ivStr,
+		Ciphertext: ciphertextStr,
+		Signature:  base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+func (p *aes256Provider) Decrypt(data *cipherData, keyIds []string, ks Keystore) ([]byte, error) {
+	if data.Version != 0 {
+		return nil, newCryptoError(CryptoProviderInvalidVersion, fmt.Sprintf("aes256 does not support envelope version %d", data.Version), nil)
+	}
+	if len(keyIds) < 2 {
+		return nil, newCryptoError(CryptoProviderMissingPrivateKey, "aes256 requires a signing key id", nil)
+	}
+
+	keyId := data.KeyId
+	if keyId == "" {
+		keyId = keyIds[0]
+	}
+	encKey, err := resolveKeyId(keyId, ks)
+	if err != nil {
+		return nil, err
+	}
+	hmacKey, err := ks.GetKey(keyIds[1])
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := base64.StdEncoding.DecodeString(data.Iv)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode iv", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(data.Ciphertext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode ciphertext", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(data.Signature)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode signature", err)
+	}
+
+	expectedSig := aes256Signature(hmacKey, keyId, data.Algorithm, data.Iv, data.Ciphertext)
+	if !hmac.Equal(expectedSig, sig) {
+		return nil, newCryptoError(CryptoProviderSignatureMismatch, "envelope signature does not match", nil)
+	}
+
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "ciphertext is not a multiple of the block size", nil)
+	}
+	block, err := aes.NewCipher(encKey)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to create aes cipher", err)
+	}
+
+	padded := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(padded, ciphertext)
+
+	return pkcs7Unpad(padded)
+}
+
+// aes256Signature computes the HMAC-SHA256 other Couchbase SDKs expect over
+// an AES-256-HMAC-SHA256 envelope: the key id, algorithm name and the
+// base64-encoded IV and ciphertext, concatenated as UTF-8 strings rather
+// than MAC'd over the raw IV/ciphertext bytes.
+func aes256Signature(hmacKey []byte, keyId, algorithm, ivStr, ciphertextStr string) []byte {
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write([]byte(keyId))
+	mac.Write([]byte(algorithm))
+	mac.Write([]byte(ivStr))
+	mac.Write([]byte(ciphertextStr))
+	return mac.Sum(nil)
+}
+
+func pkcs7Pad(data []byte, blockSize int) []byte {
+	padLen := blockSize - len(data)%blockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(padLen)}, padLen)...)
+}
+
+func pkcs7Unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "cannot unpad empty data", nil)
+	}
+	padLen := int(data[len(data)-1])
+	if padLen == 0 || padLen > len(data) {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "invalid padding", nil)
+	}
+	return data[:len(data)-padLen], nil
+}
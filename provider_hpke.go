@@ -0,0 +1,183 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+func init() {
+	registerProvider("hpke", &hpkeProvider{})
+}
+
+// algNameHPKE is the Algorithm string used for the HPKE envelope.
+const algNameHPKE = "HPKE-X25519-HKDF-SHA256-ChaCha20Poly1305"
+
+// hpkeEnvelopeVersion identifies the HPKE cipherData envelope shape: Enc
+// (the KEM-encapsulated ephemeral public key), Nonce and Ciphertext, with
+// no separate Signature since ChaCha20-Poly1305 authenticates the field.
+const hpkeEnvelopeVersion = 3
+
+// hpkeProvider implements the "hpke" cbcrypt algorithm, built from the
+// same primitives as HPKE's X25519/HKDF-SHA256/ChaCha20-Poly1305 suite: an
+// ephemeral X25519 key agreement with the recipient's public key,
+// HKDF-SHA256 to derive a ChaCha20-Poly1305 key from the raw shared
+// secret, and that AEAD to seal the field under a random nonce. It does
+// not follow RFC 9180's labeled KEM and key schedule, so its envelopes are
+// not interchangeable with those of an RFC 9180 implementation.
+//
+// Unlike rsa2048, the field
+// value is never size-limited by the recipient key, and each message uses
+// a fresh ephemeral key pair, giving forward secrecy: compromising the
+// recipient's long-term private key doesn't expose previously encrypted
+// fields.
+//
+// As with rsa2048, the first key id names the recipient's public key,
+// used to encrypt, and the second names the matching private key, used to
+// decrypt.
+type hpkeProvider struct{}
+
+func (p *hpkeProvider) Encrypt(plaintext []byte, keyIds []string, ks Keystore) (*cipherData, error) {
+	if len(keyIds) == 0 {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, "hpke requires a public key id", nil)
+	}
+	if len(keyIds) < 2 {
+		return nil, newCryptoError(CryptoProviderMissingPrivateKey, "hpke requires a private key id", nil)
+	}
+
+	recipientPubBytes, err := ks.GetKey(keyIds[0])
+	if err != nil {
+		return nil, err
+	}
+	recipientPub, err := ecdh.X25519().NewPublicKey(recipientPubBytes)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderKeySize, "hpke requires a 32 byte X25519 public key", err)
+	}
+
+	ephemeralPriv, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to generate ephemeral key pair", err)
+	}
+	sharedSecret, err := ephemeralPriv.ECDH(recipientPub)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed kem encapsulation", err)
+	}
+	enc := ephemeralPriv.PublicKey().Bytes()
+
+	keyId, err := versionedKeyId(keyIds[0], ks)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := newHPKEAEAD(sharedSecret, enc, keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to generate nonce", err)
+	}
+
+	ciphertext := aead.Seal(nil, nonce, plaintext, associatedData(algNameHPKE, keyId))
+
+	return &cipherData{
+		Version:    hpkeEnvelopeVersion,
+		Algorithm:  algNameHPKE,
+		KeyId:      keyId,
+		Nonce:      base64.StdEncoding.EncodeToString(nonce),
+		Enc:        base64.StdEncoding.EncodeToString(enc),
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+	}, nil
+}
+
+func (p *hpkeProvider) Decrypt(data *cipherData, keyIds []string, ks Keystore) ([]byte, error) {
+	if data.Version != hpkeEnvelopeVersion {
+		return nil, newCryptoError(CryptoProviderInvalidVersion, fmt.Sprintf("hpke does not support envelope version %d", data.Version), nil)
+	}
+	if len(keyIds) < 2 {
+		return nil, newCryptoError(CryptoProviderMissingPrivateKey, "hpke requires a private key id", nil)
+	}
+
+	keyId := data.KeyId
+	if keyId == "" {
+		keyId = keyIds[0]
+	}
+
+	recipientPrivBytes, err := ks.GetKey(keyIds[1])
+	if err != nil {
+		return nil, err
+	}
+	recipientPriv, err := ecdh.X25519().NewPrivateKey(recipientPrivBytes)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderKeySize, "hpke requires a 32 byte X25519 private key", err)
+	}
+
+	enc, err := base64.StdEncoding.DecodeString(data.Enc)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode kem output", err)
+	}
+	ephemeralPub, err := ecdh.X25519().NewPublicKey(enc)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to parse ephemeral public key", err)
+	}
+
+	sharedSecret, err := recipientPriv.ECDH(ephemeralPub)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed kem decapsulation", err)
+	}
+
+	aead, err := newHPKEAEAD(sharedSecret, enc, keyId)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := base64.StdEncoding.DecodeString(data.Nonce)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode nonce", err)
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(data.Ciphertext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode ciphertext", err)
+	}
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, associatedData(data.Algorithm, keyId))
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderSignatureMismatch, "failed to open hpke envelope", err)
+	}
+	return plaintext, nil
+}
+
+// newHPKEAEAD derives a per-message ChaCha20-Poly1305 key from an X25519
+// shared secret with HKDF-SHA256, with the ephemeral public key and key id
+// bound into HKDF's info so a derived key can't be replayed against a
+// different envelope.
+func newHPKEAEAD(sharedSecret, enc []byte, keyId string) (cipher.AEAD, error) {
+	info := append([]byte(enc), []byte(":"+keyId)...)
+	kdf := hkdf.New(sha256.New, sharedSecret, nil, info)
+
+	key := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(kdf, key); err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to derive hpke key", err)
+	}
+
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to create aead cipher", err)
+	}
+	return aead, nil
+}
@@ -0,0 +1,129 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+type hpkeTestStruct struct {
+	CryptString string `cbcrypt:"hpke,hpkepub,hpkepriv"`
+}
+
+func generateX25519KeyPair(t *testing.T) (pub, priv []byte) {
+	t.Helper()
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate X25519 key pair: %s", err)
+	}
+	return key.PublicKey().Bytes(), key.Bytes()
+}
+
+func TestJsonStructHPKE(t *testing.T) {
+	pub, priv := generateX25519KeyPair(t)
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"hpkepub": pub, "hpkepriv": priv}}
+
+	testObj := hpkeTestStruct{CryptString: "World"}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	encBytes, err := EncryptJsonStruct(bytes, reflect.TypeOf(testObj), keyStore)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+
+	decBytes, err := DecryptJsonStruct(encBytes, reflect.TypeOf(testObj), keyStore)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %s", err)
+	}
+
+	var decObj hpkeTestStruct
+	if err := json.Unmarshal(decBytes, &decObj); err != nil {
+		t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+	}
+	if decObj != testObj {
+		t.Fatalf("Decrypted document did not match original, got %+v", decObj)
+	}
+}
+
+func TestHPKETamperedCiphertextFailsToDecrypt(t *testing.T) {
+	pub, priv := generateX25519KeyPair(t)
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"hpkepub": pub, "hpkepriv": priv}}
+
+	testObj := hpkeTestStruct{CryptString: "World"}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	encBytes, err := EncryptJsonStruct(bytes, reflect.TypeOf(testObj), keyStore)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(encBytes, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal document: %s", err)
+	}
+	var cd cipherData
+	if err := json.Unmarshal(doc["__crypt_CryptString"], &cd); err != nil {
+		t.Fatalf("Failed to unmarshal cipher data: %s", err)
+	}
+	cd.Ciphertext = cd.Ciphertext[:len(cd.Ciphertext)-4] + "AAAA"
+	tampered, err := json.Marshal(&cd)
+	if err != nil {
+		t.Fatalf("Failed to marshal tampered cipher data: %s", err)
+	}
+	doc["__crypt_CryptString"] = tampered
+	tamperedBytes, err := json.Marshal(doc)
+	if err != nil {
+		t.Fatalf("Failed to marshal tampered document: %s", err)
+	}
+
+	_, err = DecryptJsonStruct(tamperedBytes, reflect.TypeOf(testObj), keyStore)
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderSignatureMismatch) {
+		t.Fatalf("Expected signature mismatch error, was: %v", err)
+	}
+}
+
+func TestHPKEKeySizeError(t *testing.T) {
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"hpkepub": []byte("tooshort"), "hpkepriv": []byte("tooshort")}}
+
+	testObj := hpkeTestStruct{CryptString: "World"}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	_, err = EncryptJsonStruct(bytes, reflect.TypeOf(testObj), keyStore)
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderKeySize) {
+		t.Fatalf("Expected key size error, was: %v", err)
+	}
+}
+
+func TestHPKEMissingPrivateKey(t *testing.T) {
+	type testStruct struct {
+		CryptString string `cbcrypt:"hpke,hpkepub"`
+	}
+	testObj := testStruct{CryptString: "World"}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	_, err = EncryptJsonStruct(bytes, reflect.TypeOf(testObj), &InsecureKeystore{})
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderMissingPrivateKey) {
+		t.Fatalf("Expected missing private key error, was: %v", err)
+	}
+}
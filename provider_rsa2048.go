@@ -0,0 +1,140 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+)
+
+func init() {
+	registerProvider("rsa2048", &rsa2048Provider{})
+}
+
+// algNameRSA2048 is the Algorithm string other Couchbase SDKs use for the
+// RSA-2048-OAEP envelope.
+const algNameRSA2048 = "RSA-2048-OAEP-SHA256"
+
+// rsa2048Provider implements the "rsa2048" cbcrypt algorithm: RSA-OAEP
+// encryption under the public key named by the first key id, with the
+// ciphertext signed (RSA-PKCS#1v1.5 over its SHA-256 digest) by the
+// private key named by the second key id.
+type rsa2048Provider struct{}
+
+func (p *rsa2048Provider) Encrypt(plaintext []byte, keyIds []string, ks Keystore) (*cipherData, error) {
+	if len(keyIds) == 0 {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, "rsa2048 requires a public key id", nil)
+	}
+	if len(keyIds) < 2 {
+		return nil, newCryptoError(CryptoProviderMissingSigningKey, "rsa2048 requires a signing key id", nil)
+	}
+
+	pubKeyBytes, err := ks.GetKey(keyIds[0])
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := x509.ParsePKCS1PublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to parse rsa public key", err)
+	}
+
+	ciphertext, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pubKey, plaintext, nil)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to rsa encrypt field", err)
+	}
+
+	privKeyBytes, err := ks.GetKey(keyIds[1])
+	if err != nil {
+		return nil, err
+	}
+	privKey, err := x509.ParsePKCS1PrivateKey(privKeyBytes)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to parse rsa private key", err)
+	}
+
+	digest := sha256.Sum256(ciphertext)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, privKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to sign ciphertext", err)
+	}
+
+	keyId, err := versionedKeyId(keyIds[0], ks)
+	if err != nil {
+		return nil, err
+	}
+
+	return &cipherData{
+		Algorithm:  algNameRSA2048,
+		KeyId:      keyId,
+		Ciphertext: base64.StdEncoding.EncodeToString(ciphertext),
+		Signature:  base64.StdEncoding.EncodeToString(sig),
+	}, nil
+}
+
+func (p *rsa2048Provider) Decrypt(data *cipherData, keyIds []string, ks Keystore) ([]byte, error) {
+	if data.Version != 0 {
+		return nil, newCryptoError(CryptoProviderInvalidVersion, "rsa2048 does not support this envelope version", nil)
+	}
+	if len(keyIds) < 2 {
+		return nil, newCryptoError(CryptoProviderMissingSigningKey, "rsa2048 requires a signing key id", nil)
+	}
+
+	pubKeyId := data.KeyId
+	if pubKeyId == "" {
+		pubKeyId = keyIds[0]
+	}
+	pubKeyBytes, err := resolveKeyId(pubKeyId, ks)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := x509.ParsePKCS1PublicKey(pubKeyBytes)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to parse rsa public key", err)
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(data.Ciphertext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode ciphertext", err)
+	}
+	sig, err := base64.StdEncoding.DecodeString(data.Signature)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode signature", err)
+	}
+
+	digest := sha256.Sum256(ciphertext)
+	if err := rsa.VerifyPKCS1v15(pubKey, crypto.SHA256, digest[:], sig); err != nil {
+		return nil, newCryptoError(CryptoProviderSignatureMismatch, "envelope signature does not match", err)
+	}
+
+	privKeyBytes, err := ks.GetKey(keyIds[1])
+	if err != nil {
+		return nil, err
+	}
+	privKey, err := x509.ParsePKCS1PrivateKey(privKeyBytes)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to parse rsa private key", err)
+	}
+
+	plaintext, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privKey, ciphertext, nil)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to rsa decrypt field", err)
+	}
+	return plaintext, nil
+}
+
+func marshalPKCS1PrivateKey(key *rsa.PrivateKey) []byte {
+	return x509.MarshalPKCS1PrivateKey(key)
+}
+
+func marshalPKCS1PublicKey(key *rsa.PublicKey) []byte {
+	return x509.MarshalPKCS1PublicKey(key)
+}
@@ -0,0 +1,266 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+)
+
+func init() {
+	registerProvider("aes256-siv", &sivProvider{})
+}
+
+// algNameAES256SIV is the Algorithm string used for the deterministic
+// AES-SIV envelope.
+const algNameAES256SIV = "AES-256-SIV"
+
+// sivEnvelopeVersion identifies the AES-SIV cipherData envelope shape: a
+// single Ciphertext field holding the synthetic IV followed by the
+// ciphertext, and no separate Nonce or Signature.
+const sivEnvelopeVersion = 2
+
+// sivProvider implements the "aes256-siv" cbcrypt algorithm: deterministic,
+// nonce-misuse-resistant authenticated encryption using AES-SIV (RFC 5297),
+// built directly on stdlib crypto/aes rather than a third-party SIV
+// package. Its synthetic IV is derived from the key, associated data and
+// plaintext via S2V, so encrypting the same plaintext under the same key
+// always produces the same ciphertext. That determinism lets equality
+// predicates and secondary indexes match against the encrypted value in
+// N1QL without decrypting server-side, at the cost of leaking whether two
+// encrypted fields hold the same plaintext.
+//
+// Use aes256-siv only for fields that need to be queried for equality,
+// such as an email address used as a lookup key; fields with no such
+// requirement, like a social security number, should stay on one of the
+// randomized AEAD algorithms (aes256-gcm, chacha20-poly1305,
+// xchacha20-poly1305) so that repeated values aren't distinguishable.
+type sivProvider struct{}
+
+func (p *sivProvider) Encrypt(plaintext []byte, keyIds []string, ks Keystore) (*cipherData, error) {
+	if len(keyIds) == 0 {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, "aes256-siv requires an encryption key id", nil)
+	}
+
+	key, err := ks.GetKey(keyIds[0])
+	if err != nil {
+		return nil, err
+	}
+	macKey, ctrKey, err := splitSIVKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	keyId, err := versionedKeyId(keyIds[0], ks)
+	if err != nil {
+		return nil, err
+	}
+
+	// SIV's synthetic IV is derived from the key, associated data and
+	// plaintext, so a deterministic counter derived from it is safe and
+	// keeps the ciphertext a deterministic function of those three
+	// inputs alone.
+	siv, err := s2v(macKey, associatedData(algNameAES256SIV, keyId), plaintext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to compute synthetic iv", err)
+	}
+	ciphertext, err := sivCTR(ctrKey, siv, plaintext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to encrypt plaintext", err)
+	}
+
+	return &cipherData{
+		Version:    sivEnvelopeVersion,
+		Algorithm:  algNameAES256SIV,
+		KeyId:      keyId,
+		Ciphertext: base64.StdEncoding.EncodeToString(append(siv, ciphertext...)),
+	}, nil
+}
+
+func (p *sivProvider) Decrypt(data *cipherData, keyIds []string, ks Keystore) ([]byte, error) {
+	if data.Version != sivEnvelopeVersion {
+		return nil, newCryptoError(CryptoProviderInvalidVersion, fmt.Sprintf("aes256-siv does not support envelope version %d", data.Version), nil)
+	}
+
+	keyId := data.KeyId
+	if keyId == "" {
+		if len(keyIds) == 0 {
+			return nil, newCryptoError(CryptoProviderMissingPublicKey, "aes256-siv requires an encryption key id", nil)
+		}
+		keyId = keyIds[0]
+	}
+
+	key, err := resolveKeyId(keyId, ks)
+	if err != nil {
+		return nil, err
+	}
+	macKey, ctrKey, err := splitSIVKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(data.Ciphertext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decode ciphertext", err)
+	}
+	if len(sealed) < aes.BlockSize {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "ciphertext is shorter than the synthetic iv", nil)
+	}
+	siv, ciphertext := sealed[:aes.BlockSize], sealed[aes.BlockSize:]
+
+	plaintext, err := sivCTR(ctrKey, siv, ciphertext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to decrypt ciphertext", err)
+	}
+
+	expectedSiv, err := s2v(macKey, associatedData(data.Algorithm, keyId), plaintext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to compute synthetic iv", err)
+	}
+	if subtle.ConstantTimeCompare(expectedSiv, siv) != 1 {
+		return nil, newCryptoError(CryptoProviderSignatureMismatch, "failed to open siv envelope", nil)
+	}
+	return plaintext, nil
+}
+
+// splitSIVKey splits a 64 byte cbcrypt key into AES-SIV's two AES-256
+// subkeys: the first half MACs via S2V, the second encrypts via CTR.
+func splitSIVKey(key []byte) (macKey, ctrKey []byte, err error) {
+	if len(key) != 64 {
+		return nil, nil, newCryptoError(CryptoProviderKeySize, fmt.Sprintf("aes256-siv requires a 64 byte key (two AES-256 subkeys), got %d bytes", len(key)), nil)
+	}
+	return key[:32], key[32:], nil
+}
+
+// sivCTR encrypts (or, since CTR is its own inverse, decrypts) input under
+// key, using siv as the initial counter block with the top bit of each of
+// its last two 32-bit words (bytes 8 and 12) cleared, per RFC 5297
+// section 2.5. Clearing those bits keeps increments of the low 32 or 64
+// bits from carrying further, so implementations that increment only
+// those bits produce the same keystream.
+func sivCTR(key, siv, input []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	counter := append([]byte(nil), siv...)
+	counter[len(counter)-8] &= 0x7f
+	counter[len(counter)-4] &= 0x7f
+
+	out := make([]byte, len(input))
+	cipher.NewCTR(block, counter).XORKeyStream(out, input)
+	return out, nil
+}
+
+// s2v implements RFC 5297's S2V, keyed by an AES-256 key: it folds a CMAC
+// of each of components[:len(components)-1] (the associated data) into a
+// running value via doubling in GF(2^128), then MACs that value xored
+// (or, for a short final component, doubled and xored) with the final
+// component (the plaintext) to produce AES-SIV's synthetic IV.
+func s2v(key []byte, components ...[]byte) ([]byte, error) {
+	d, err := aesCMAC(key, make([]byte, aes.BlockSize))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range components[:len(components)-1] {
+		c, err := aesCMAC(key, s)
+		if err != nil {
+			return nil, err
+		}
+		d = xorBytes(dbl(d), c)
+	}
+
+	final := components[len(components)-1]
+	var t []byte
+	if len(final) >= aes.BlockSize {
+		t = xorEnd(final, d)
+	} else {
+		t = xorBytes(dbl(d), pad(final))
+	}
+	return aesCMAC(key, t)
+}
+
+// aesCMAC computes the AES-CMAC (RFC 4493) of msg under an AES-256 key.
+func aesCMAC(key, msg []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := block.BlockSize()
+
+	zero := make([]byte, blockSize)
+	l := make([]byte, blockSize)
+	block.Encrypt(l, zero)
+	k1 := dbl(l)
+	k2 := dbl(k1)
+
+	var lastBlock []byte
+	n := len(msg)
+	if n != 0 && n%blockSize == 0 {
+		lastBlock = xorBytes(msg[n-blockSize:], k1)
+		n -= blockSize
+	} else {
+		lastBlock = xorBytes(pad(msg[n-n%blockSize:]), k2)
+		n -= n % blockSize
+	}
+
+	x := make([]byte, blockSize)
+	for i := 0; i < n; i += blockSize {
+		y := xorBytes(x, msg[i:i+blockSize])
+		block.Encrypt(x, y)
+	}
+	mac := make([]byte, blockSize)
+	block.Encrypt(mac, xorBytes(x, lastBlock))
+	return mac, nil
+}
+
+// dbl multiplies a block by x in GF(2^128), per RFC 5297 section 2.3.
+func dbl(in []byte) []byte {
+	out := make([]byte, len(in))
+	var carry byte
+	for i := len(in) - 1; i >= 0; i-- {
+		out[i] = (in[i] << 1) | carry
+		carry = in[i] >> 7
+	}
+	if carry != 0 {
+		out[len(out)-1] ^= 0x87
+	}
+	return out
+}
+
+// pad applies CMAC's 10*-padding, appending a single 1 bit then zero bits
+// up to a full AES block.
+func pad(in []byte) []byte {
+	out := make([]byte, aes.BlockSize)
+	copy(out, in)
+	out[len(in)] = 0x80
+	return out
+}
+
+func xorBytes(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// xorEnd xors the last len(b) bytes of a (which must be at least as long
+// as b) with b, leaving any leading bytes of a unchanged.
+func xorEnd(a, b []byte) []byte {
+	out := append([]byte(nil), a...)
+	offset := len(a) - len(b)
+	for i, v := range b {
+		out[offset+i] ^= v
+	}
+	return out
+}
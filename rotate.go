@@ -0,0 +1,102 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+)
+
+// RotateJsonStruct decrypts every field of t tagged with `cbcrypt` within
+// the JSON document data using the key version recorded in its envelope,
+// then re-encrypts it with ks's current key version. It returns the
+// updated document and whether anything actually changed, so that callers
+// migrating a whole bucket can skip writing back documents that were
+// already encrypted with the current key version.
+//
+// A bulk migration loop typically looks like:
+//
+//	rows, err := cluster.Query("SELECT META().id FROM `bucket`", nil)
+//	for rows.Next() {
+//	    var row struct{ Id string }
+//	    rows.Row(&row)
+//
+//	    var doc []byte
+//	    result, err := collection.Get(row.Id, nil)
+//	    result.Content(&doc)
+//
+//	    rotated, changed, err := gocbfieldcrypt.RotateJsonStruct(doc, reflect.TypeOf(MyDoc{}), keystore)
+//	    if changed {
+//	        collection.Replace(row.Id, json.RawMessage(rotated), &gocb.ReplaceOptions{Cas: result.Cas()})
+//	    }
+//	}
+func RotateJsonStruct(data []byte, t reflect.Type, ks Keystore) ([]byte, bool, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, false, newCryptoError(CryptoProviderDecryptFailed, "failed to unmarshal document", err)
+	}
+
+	changed := false
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("cbcrypt")
+		if !ok {
+			continue
+		}
+		cryptTag := parseCbcryptTag(tag)
+		name := jsonFieldName(field)
+		encName := fieldPrefix + name
+
+		raw, ok := doc[encName]
+		if !ok {
+			continue
+		}
+
+		var cd cipherData
+		if err := json.Unmarshal(raw, &cd); err != nil {
+			return nil, false, newCryptoError(CryptoProviderDecryptFailed, fmt.Sprintf("failed to unmarshal cipher data for %q", name), err)
+		}
+
+		provider, err := providerByName(cryptTag.Algorithm)
+		if err != nil {
+			return nil, false, err
+		}
+
+		plaintext, err := provider.Decrypt(&cd, cryptTag.KeyIds, ks)
+		if err != nil {
+			return nil, false, err
+		}
+
+		newCd, err := provider.Encrypt(plaintext, cryptTag.KeyIds, ks)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if newCd.KeyId == cd.KeyId {
+			continue
+		}
+		changed = true
+
+		encoded, err := json.Marshal(newCd)
+		if err != nil {
+			return nil, false, newCryptoError(CryptoProviderEncryptFailed, "failed to marshal cipher data", err)
+		}
+		doc[encName] = encoded
+	}
+
+	if !changed {
+		return data, false, nil
+	}
+
+	out, err := json.Marshal(doc)
+	if err != nil {
+		return nil, false, newCryptoError(CryptoProviderEncryptFailed, "failed to marshal document", err)
+	}
+	return out, true, nil
+}
@@ -0,0 +1,137 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+// versionedTestKeystore is a VersionedKeystore backed by an in-memory map of
+// key versions, so tests can exercise RotateJsonStruct without a real KMS.
+type versionedTestKeystore struct {
+	keys    map[string]map[string][]byte
+	current map[string]string
+}
+
+func (ks *versionedTestKeystore) GetKey(keyId string) ([]byte, error) {
+	return ks.GetKeyVersion(keyId, ks.current[keyId])
+}
+
+func (ks *versionedTestKeystore) GetKeyVersion(keyId string, version string) ([]byte, error) {
+	versions, ok := ks.keys[keyId]
+	if !ok {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, "no such key", nil)
+	}
+	key, ok := versions[version]
+	if !ok {
+		return nil, newCryptoError(CryptoProviderMissingPublicKey, "no such key version", nil)
+	}
+	return key, nil
+}
+
+func (ks *versionedTestKeystore) CurrentKeyVersion(keyId string) (string, error) {
+	return ks.current[keyId], nil
+}
+
+func (ks *versionedTestKeystore) Close() error {
+	return nil
+}
+
+type rotateTestStruct struct {
+	CryptString string `cbcrypt:"aes256-gcm,mykey"`
+}
+
+func TestRotateJsonStructReencryptsUnderCurrentVersion(t *testing.T) {
+	keyV1 := make([]byte, 32)
+	keyV2 := make([]byte, 32)
+	for i := range keyV2 {
+		keyV2[i] = byte(i + 1)
+	}
+
+	ks := &versionedTestKeystore{
+		keys:    map[string]map[string][]byte{"mykey": {"1": keyV1, "2": keyV2}},
+		current: map[string]string{"mykey": "1"},
+	}
+
+	testObj := rotateTestStruct{CryptString: "World"}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	encBytes, err := EncryptJsonStruct(bytes, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+
+	ks.current["mykey"] = "2"
+
+	rotated, changed, err := RotateJsonStruct(encBytes, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to rotate: %s", err)
+	}
+	if !changed {
+		t.Fatalf("Expected rotation to report a change after the key version moved")
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(rotated, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal rotated document: %s", err)
+	}
+	var cd cipherData
+	if err := json.Unmarshal(doc["__crypt_CryptString"], &cd); err != nil {
+		t.Fatalf("Failed to unmarshal cipher data: %s", err)
+	}
+	if cd.KeyId != "mykey:2" {
+		t.Fatalf("Expected rotated envelope to be stamped with the new key version, got KeyId %q", cd.KeyId)
+	}
+
+	decBytes, err := DecryptJsonStruct(rotated, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to decrypt rotated document: %s", err)
+	}
+	var decObj rotateTestStruct
+	if err := json.Unmarshal(decBytes, &decObj); err != nil {
+		t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+	}
+	if decObj != testObj {
+		t.Fatalf("Decrypted document did not match original, got %+v", decObj)
+	}
+}
+
+func TestRotateJsonStructNoopWhenAlreadyCurrent(t *testing.T) {
+	key := make([]byte, 32)
+	ks := &versionedTestKeystore{
+		keys:    map[string]map[string][]byte{"mykey": {"1": key}},
+		current: map[string]string{"mykey": "1"},
+	}
+
+	testObj := rotateTestStruct{CryptString: "World"}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	encBytes, err := EncryptJsonStruct(bytes, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+
+	rotated, changed, err := RotateJsonStruct(encBytes, reflect.TypeOf(testObj), ks)
+	if err != nil {
+		t.Fatalf("Failed to rotate: %s", err)
+	}
+	if changed {
+		t.Fatalf("Expected no change when the key version hadn't moved")
+	}
+	if string(rotated) != string(encBytes) {
+		t.Fatalf("Expected the unchanged document to be returned as-is")
+	}
+}
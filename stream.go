@@ -0,0 +1,295 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// DefaultStreamThreshold is the plaintext size, in bytes, above which
+// EncryptJsonStructWithOptions switches a tagged field from its ordinary
+// inline envelope to a streaming one held in an AttachmentStore.
+const DefaultStreamThreshold = 1 << 20 // 1 MiB
+
+// streamFrameSize is the size of each plaintext frame sealed by
+// EncryptJsonStreamField.
+const streamFrameSize = 64 * 1024
+
+// streamEnvelopeVersion identifies the streaming cipherData envelope
+// shape: Ciphertext holds an AttachmentStore key rather than the field's
+// data, which is written separately as a header and frames by
+// EncryptJsonStreamField.
+const streamEnvelopeVersion = 5
+
+// AttachmentStore persists the framed output of EncryptJsonStreamField
+// outside the JSON document itself, keyed by an opaque string chosen by
+// the caller of EncryptJsonStructWithOptions.
+type AttachmentStore interface {
+	// Put stores the bytes read from r under key. size is the total
+	// number of bytes that will be read from r, or -1 if unknown.
+	Put(key string, r io.Reader, size int64) error
+
+	// Get returns the bytes previously stored under key.
+	Get(key string) (io.ReadCloser, error)
+}
+
+// StreamOptions configures when EncryptJsonStructWithOptions streams a
+// tagged field to an AttachmentStore instead of storing it inline.
+type StreamOptions struct {
+	// Threshold is the plaintext size, in bytes, above which a tagged
+	// field is streamed. Zero disables streaming entirely.
+	Threshold int
+
+	// Attachments stores and retrieves streamed fields. Required
+	// whenever Threshold is non-zero.
+	Attachments AttachmentStore
+}
+
+// DefaultStreamOptions streams any field over DefaultStreamThreshold into
+// attachments.
+func DefaultStreamOptions(attachments AttachmentStore) StreamOptions {
+	return StreamOptions{Threshold: DefaultStreamThreshold, Attachments: attachments}
+}
+
+func (o StreamOptions) shouldStream(plaintextSize int) bool {
+	return o.Threshold > 0 && plaintextSize > o.Threshold
+}
+
+func (o StreamOptions) encryptStreamed(plaintext json.RawMessage, tag cbcryptTag, ks Keystore) (*cipherData, error) {
+	if o.Attachments == nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "field exceeds the streaming threshold but no AttachmentStore is configured", nil)
+	}
+
+	keyBuf := make([]byte, 16)
+	if _, err := rand.Read(keyBuf); err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to generate attachment key", err)
+	}
+	blobKey := hex.EncodeToString(keyBuf)
+
+	pr, pw := io.Pipe()
+	var keyId string
+	var streamErr error
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var err error
+		_, keyId, err = EncryptJsonStreamField(bytes.NewReader(plaintext), pw, int64(len(plaintext)), tag.Algorithm, tag.KeyIds, ks)
+		if err != nil {
+			streamErr = err
+			pw.CloseWithError(err)
+			return
+		}
+		pw.Close()
+	}()
+
+	if err := o.Attachments.Put(blobKey, pr, -1); err != nil {
+		return nil, newCryptoError(CryptoProviderEncryptFailed, "failed to store stream attachment", err)
+	}
+	<-done
+	if streamErr != nil {
+		return nil, streamErr
+	}
+
+	return &cipherData{
+		Version:    streamEnvelopeVersion,
+		Algorithm:  tag.Algorithm,
+		KeyId:      keyId,
+		Ciphertext: blobKey,
+	}, nil
+}
+
+func (o StreamOptions) decryptStreamed(cd *cipherData, keyIds []string, ks Keystore) ([]byte, error) {
+	if o.Attachments == nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "field references a stream attachment but no AttachmentStore is configured", nil)
+	}
+
+	rc, err := o.Attachments.Get(cd.Ciphertext)
+	if err != nil {
+		return nil, newCryptoError(CryptoProviderDecryptFailed, "failed to fetch stream attachment", err)
+	}
+	defer rc.Close()
+
+	var buf bytes.Buffer
+	if err := DecryptJsonStreamField(rc, &buf, cd.Algorithm, keyIds, ks); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// streamableProvider is implemented by AEAD providers whose cipher can be
+// reused across frames by EncryptJsonStreamField/DecryptJsonStreamField.
+// Providers that authenticate via a separate Signature (aes256, rsa2048)
+// or that are inherently single-shot (aes256-siv, hpke) don't implement
+// it.
+type streamableProvider interface {
+	encryptAEAD(keyIds []string, ks Keystore) (aead cipher.AEAD, keyId string, err error)
+	decryptAEAD(keyId string, ks Keystore) (cipher.AEAD, error)
+}
+
+// streamHeader is written once at the start of a stream produced by
+// EncryptJsonStreamField, before any frames.
+type streamHeader struct {
+	Algorithm  string `json:"alg"`
+	KeyId      string `json:"kid"`
+	ChunkSize  int    `json:"chunkSize"`
+	FrameCount int    `json:"frames"`
+	BaseNonce  string `json:"baseNonce"`
+}
+
+// EncryptJsonStreamField encrypts the size bytes read from r in fixed-size
+// frames, writing a header followed by each sealed frame to w. Every
+// frame is sealed under the same key with a nonce formed by XORing its
+// big-endian frame counter into a random per-stream base nonce, so no two
+// frames ever reuse a nonce. It returns the number of frames written and
+// the (possibly version-stamped) key id used, for embedding in a
+// cipherData envelope.
+func EncryptJsonStreamField(r io.Reader, w io.Writer, size int64, algorithm string, keyIds []string, ks Keystore) (frameCount int, keyId string, err error) {
+	provider, err := providerByName(algorithm)
+	if err != nil {
+		return 0, "", err
+	}
+	streamable, ok := provider.(streamableProvider)
+	if !ok {
+		return 0, "", newCryptoError(CryptoProviderEncryptFailed, fmt.Sprintf("%s does not support streaming", algorithm), nil)
+	}
+
+	aead, keyId, err := streamable.encryptAEAD(keyIds, ks)
+	if err != nil {
+		return 0, "", err
+	}
+
+	baseNonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(baseNonce); err != nil {
+		return 0, "", newCryptoError(CryptoProviderEncryptFailed, "failed to generate base nonce", err)
+	}
+
+	frameCount = int((size + streamFrameSize - 1) / streamFrameSize)
+
+	header := streamHeader{
+		Algorithm:  algorithm,
+		KeyId:      keyId,
+		ChunkSize:  streamFrameSize,
+		FrameCount: frameCount,
+		BaseNonce:  base64.StdEncoding.EncodeToString(baseNonce),
+	}
+	headerBytes, err := json.Marshal(&header)
+	if err != nil {
+		return 0, "", newCryptoError(CryptoProviderEncryptFailed, "failed to marshal stream header", err)
+	}
+	if err := writeStreamBlock(w, headerBytes); err != nil {
+		return 0, "", err
+	}
+
+	ad := associatedData(algorithm, keyId)
+	buf := make([]byte, streamFrameSize)
+	for i := 0; i < frameCount; i++ {
+		n, readErr := io.ReadFull(r, buf)
+		if readErr != nil && readErr != io.ErrUnexpectedEOF && readErr != io.EOF {
+			return i, keyId, newCryptoError(CryptoProviderEncryptFailed, "failed to read plaintext", readErr)
+		}
+
+		frame := aead.Seal(nil, frameNonce(baseNonce, i), buf[:n], ad)
+		if err := writeStreamBlock(w, frame); err != nil {
+			return i, keyId, err
+		}
+	}
+	return frameCount, keyId, nil
+}
+
+// DecryptJsonStreamField reverses EncryptJsonStreamField, reading a header
+// and its frames from r and writing the reassembled plaintext to w.
+func DecryptJsonStreamField(r io.Reader, w io.Writer, algorithm string, keyIds []string, ks Keystore) error {
+	provider, err := providerByName(algorithm)
+	if err != nil {
+		return err
+	}
+	streamable, ok := provider.(streamableProvider)
+	if !ok {
+		return newCryptoError(CryptoProviderDecryptFailed, fmt.Sprintf("%s does not support streaming", algorithm), nil)
+	}
+
+	headerBytes, err := readStreamBlock(r)
+	if err != nil {
+		return newCryptoError(CryptoProviderDecryptFailed, "failed to read stream header", err)
+	}
+	var header streamHeader
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return newCryptoError(CryptoProviderDecryptFailed, "failed to unmarshal stream header", err)
+	}
+
+	aead, err := streamable.decryptAEAD(header.KeyId, ks)
+	if err != nil {
+		return err
+	}
+
+	baseNonce, err := base64.StdEncoding.DecodeString(header.BaseNonce)
+	if err != nil {
+		return newCryptoError(CryptoProviderDecryptFailed, "failed to decode base nonce", err)
+	}
+
+	ad := associatedData(header.Algorithm, header.KeyId)
+	for i := 0; i < header.FrameCount; i++ {
+		frame, err := readStreamBlock(r)
+		if err != nil {
+			return newCryptoError(CryptoProviderDecryptFailed, "failed to read stream frame", err)
+		}
+
+		plaintext, err := aead.Open(nil, frameNonce(baseNonce, i), frame, ad)
+		if err != nil {
+			return newCryptoError(CryptoProviderSignatureMismatch, "failed to open stream frame", err)
+		}
+		if _, err := w.Write(plaintext); err != nil {
+			return newCryptoError(CryptoProviderDecryptFailed, "failed to write plaintext", err)
+		}
+	}
+	return nil
+}
+
+// frameNonce derives frame i's nonce from a per-stream base nonce by
+// XORing i, big-endian, into its final bytes.
+func frameNonce(base []byte, i int) []byte {
+	nonce := append([]byte(nil), base...)
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(i))
+	for j := 0; j < 8 && j < len(nonce); j++ {
+		nonce[len(nonce)-1-j] ^= counter[7-j]
+	}
+	return nonce
+}
+
+func writeStreamBlock(w io.Writer, data []byte) error {
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	if _, err := w.Write(lenBuf[:]); err != nil {
+		return newCryptoError(CryptoProviderEncryptFailed, "failed to write stream block length", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return newCryptoError(CryptoProviderEncryptFailed, "failed to write stream block", err)
+	}
+	return nil
+}
+
+func readStreamBlock(r io.Reader) ([]byte, error) {
+	var lenBuf [4]byte
+	if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
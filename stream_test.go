@@ -0,0 +1,142 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package gocbfieldcrypt
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// memoryAttachmentStore is an AttachmentStore backed by an in-memory map,
+// for use in tests in place of a real blob store.
+type memoryAttachmentStore struct {
+	blobs map[string][]byte
+}
+
+func (s *memoryAttachmentStore) Put(key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if s.blobs == nil {
+		s.blobs = make(map[string][]byte)
+	}
+	s.blobs[key] = data
+	return nil
+}
+
+func (s *memoryAttachmentStore) Get(key string) (io.ReadCloser, error) {
+	data, ok := s.blobs[key]
+	if !ok {
+		return nil, errors.New("no such attachment")
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+type streamTestStruct struct {
+	CryptString string `cbcrypt:"aes256-gcm,streamkey"`
+}
+
+func TestJsonStructStreamedField(t *testing.T) {
+	testKey, _ := hex.DecodeString("1234567890123456123456789012345612345678901234561234567890123456")
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"streamkey": testKey}}
+	store := &memoryAttachmentStore{}
+	opts := StreamOptions{Threshold: 10, Attachments: store}
+
+	testObj := streamTestStruct{CryptString: strings.Repeat("x", streamFrameSize+1024)}
+	bytes, err := json.Marshal(testObj)
+	if err != nil {
+		t.Fatalf("Failed to marshal: %s", err)
+	}
+
+	encBytes, err := EncryptJsonStructWithOptions(bytes, reflect.TypeOf(testObj), keyStore, opts)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %s", err)
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(encBytes, &doc); err != nil {
+		t.Fatalf("Failed to unmarshal encrypted document: %s", err)
+	}
+	var cd cipherData
+	if err := json.Unmarshal(doc["__crypt_CryptString"], &cd); err != nil {
+		t.Fatalf("Failed to unmarshal cipher data: %s", err)
+	}
+	if cd.Version != streamEnvelopeVersion {
+		t.Fatalf("Expected the streamed field to use envelope version %d, got %d", streamEnvelopeVersion, cd.Version)
+	}
+	if _, ok := store.blobs[cd.Ciphertext]; !ok {
+		t.Fatalf("Expected an attachment to be stored under key %q", cd.Ciphertext)
+	}
+
+	decBytes, err := DecryptJsonStructWithOptions(encBytes, reflect.TypeOf(testObj), keyStore, opts)
+	if err != nil {
+		t.Fatalf("Failed to decrypt: %s", err)
+	}
+	var decObj streamTestStruct
+	if err := json.Unmarshal(decBytes, &decObj); err != nil {
+		t.Fatalf("Failed to unmarshal decrypted document: %s", err)
+	}
+	if decObj != testObj {
+		t.Fatalf("Decrypted document did not match original")
+	}
+}
+
+func TestEncryptDecryptJsonStreamField(t *testing.T) {
+	testKey, _ := hex.DecodeString("1234567890123456123456789012345612345678901234561234567890123456")
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"streamkey": testKey}}
+
+	plaintext := []byte(strings.Repeat("y", streamFrameSize*2+100))
+
+	var sealed bytes.Buffer
+	frameCount, keyId, err := EncryptJsonStreamField(bytes.NewReader(plaintext), &sealed, int64(len(plaintext)), "aes256-gcm", []string{"streamkey"}, keyStore)
+	if err != nil {
+		t.Fatalf("Failed to encrypt stream: %s", err)
+	}
+	if frameCount != 3 {
+		t.Fatalf("Expected 3 frames for a 2*chunk+100 byte stream, got %d", frameCount)
+	}
+	if keyId != "streamkey" {
+		t.Fatalf("Unexpected key id: %q", keyId)
+	}
+
+	var recovered bytes.Buffer
+	if err := DecryptJsonStreamField(bytes.NewReader(sealed.Bytes()), &recovered, "aes256-gcm", []string{"streamkey"}, keyStore); err != nil {
+		t.Fatalf("Failed to decrypt stream: %s", err)
+	}
+	if !bytes.Equal(recovered.Bytes(), plaintext) {
+		t.Fatalf("Decrypted stream did not match original plaintext")
+	}
+}
+
+func TestDecryptJsonStreamFieldTamperedFrameFailsToDecrypt(t *testing.T) {
+	testKey, _ := hex.DecodeString("1234567890123456123456789012345612345678901234561234567890123456")
+	keyStore := &InsecureKeystore{Keys: map[string][]byte{"streamkey": testKey}}
+
+	plaintext := []byte(strings.Repeat("z", 100))
+
+	var sealed bytes.Buffer
+	if _, _, err := EncryptJsonStreamField(bytes.NewReader(plaintext), &sealed, int64(len(plaintext)), "aes256-gcm", []string{"streamkey"}, keyStore); err != nil {
+		t.Fatalf("Failed to encrypt stream: %s", err)
+	}
+
+	tampered := sealed.Bytes()
+	tampered[len(tampered)-1] ^= 0xFF
+
+	var recovered bytes.Buffer
+	err := DecryptJsonStreamField(bytes.NewReader(tampered), &recovered, "aes256-gcm", []string{"streamkey"}, keyStore)
+	if err == nil || !IsCryptoErrorType(err, CryptoProviderSignatureMismatch) {
+		t.Fatalf("Expected signature mismatch error, was: %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+// Package vaulttransit implements a gocbfieldcrypt.Keystore backed by
+// HashiCorp Vault's Transit secrets engine. It lives in its own package,
+// rather than the core gocbfieldcrypt package, so that consumers who don't
+// use Vault aren't forced to pull in its API client.
+package vaulttransit
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/couchbaselabs/gocbfieldcrypt"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// writer is the subset of Vault's Logical API this Keystore needs. It lets
+// tests substitute a fake Transit backend without a real Vault client.
+type writer interface {
+	Write(path string, data map[string]interface{}) (*vaultapi.Secret, error)
+}
+
+// Keystore is a gocbfieldcrypt.Keystore that resolves key ids through
+// Vault's Transit engine. A key id is a Vault Transit ciphertext (e.g.
+// "vault:v1:...") produced once, out of band, by encrypting the raw key
+// material under TransitKey; GetKey calls Transit's decrypt endpoint to
+// recover the plaintext key.
+type Keystore struct {
+	Client     writer
+	MountPath  string
+	TransitKey string
+}
+
+// New returns a Keystore that decrypts key material with transitKey, under
+// Vault's Transit engine mounted at mountPath (typically "transit"),
+// through client.
+func New(client *vaultapi.Client, mountPath string, transitKey string) *Keystore {
+	return &Keystore{Client: client.Logical(), MountPath: mountPath, TransitKey: transitKey}
+}
+
+// GetKey implements the gocbfieldcrypt.Keystore interface.
+func (ks *Keystore) GetKey(keyId string) ([]byte, error) {
+	return ks.GetKeyVersion(keyId, "")
+}
+
+// GetKeyVersion implements the gocbfieldcrypt.Keystore interface. Vault
+// Transit ciphertexts are self-describing, so version is ignored.
+func (ks *Keystore) GetKeyVersion(keyId string, version string) ([]byte, error) {
+	secret, err := ks.Client.Write(
+		fmt.Sprintf("%s/decrypt/%s", ks.MountPath, ks.TransitKey),
+		map[string]interface{}{"ciphertext": keyId},
+	)
+	if err != nil {
+		return nil, &gocbfieldcrypt.CryptoError{
+			Type:    gocbfieldcrypt.CryptoProviderDecryptFailed,
+			Message: "failed to decrypt key via Vault Transit",
+			Cause:   err,
+		}
+	}
+
+	plaintext, ok := secret.Data["plaintext"].(string)
+	if !ok {
+		return nil, &gocbfieldcrypt.CryptoError{
+			Type:    gocbfieldcrypt.CryptoProviderDecryptFailed,
+			Message: "Vault Transit response did not contain a plaintext field",
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(plaintext)
+	if err != nil {
+		return nil, &gocbfieldcrypt.CryptoError{
+			Type:    gocbfieldcrypt.CryptoProviderDecryptFailed,
+			Message: "failed to decode Vault Transit plaintext",
+			Cause:   err,
+		}
+	}
+	return key, nil
+}
+
+// Close implements the gocbfieldcrypt.Keystore interface. The Vault API
+// client holds no resources that need explicit cleanup.
+func (ks *Keystore) Close() error {
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright (c) 2018 Couchbase, Inc.
+ *
+ * Use of this software is subject to the Couchbase Inc. Enterprise Subscription License Agreement
+ * which may be found at https://www.couchbase.com/ESLA-11132015.
+ */
+
+package vaulttransit
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+
+	"github.com/couchbaselabs/gocbfieldcrypt"
+	vaultapi "github.com/hashicorp/vault/api"
+)
+
+// fakeWriter stands in for Vault's Transit engine, returning a fixed
+// plaintext for a known ciphertext without making a real call.
+type fakeWriter struct {
+	path       string
+	ciphertext string
+	plaintext  string
+	err        error
+}
+
+func (f *fakeWriter) Write(path string, data map[string]interface{}) (*vaultapi.Secret, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	f.path = path
+	if data["ciphertext"] != f.ciphertext {
+		return nil, errors.New("unknown ciphertext")
+	}
+	out := map[string]interface{}{}
+	if f.plaintext != "" {
+		out["plaintext"] = f.plaintext
+	}
+	return &vaultapi.Secret{Data: out}, nil
+}
+
+func TestKeystoreGetKey(t *testing.T) {
+	plaintext := base64.StdEncoding.EncodeToString([]byte("the-plaintext-key"))
+	fake := &fakeWriter{ciphertext: "vault:v1:abcd", plaintext: plaintext}
+	ks := &Keystore{Client: fake, MountPath: "transit", TransitKey: "mykey"}
+
+	key, err := ks.GetKey("vault:v1:abcd")
+	if err != nil {
+		t.Fatalf("Failed to get key: %s", err)
+	}
+	if string(key) != "the-plaintext-key" {
+		t.Fatalf("Unexpected key: %q", key)
+	}
+	if fake.path != "transit/decrypt/mykey" {
+		t.Fatalf("Unexpected Transit path: %q", fake.path)
+	}
+}
+
+func TestKeystoreGetKeyMissingPlaintext(t *testing.T) {
+	fake := &fakeWriter{ciphertext: "vault:v1:abcd"}
+	fake.plaintext = ""
+	ks := &Keystore{Client: fake, MountPath: "transit", TransitKey: "mykey"}
+
+	// With no plaintext configured, the fake answers the known ciphertext
+	// with a secret that has no "plaintext" field set at all.
+	_, err := ks.GetKey("vault:v1:abcd")
+	if !gocbfieldcrypt.IsCryptoErrorType(err, gocbfieldcrypt.CryptoProviderDecryptFailed) {
+		t.Fatalf("Expected decrypt failed error, was: %v", err)
+	}
+}
+
+func TestKeystoreGetKeyWriteError(t *testing.T) {
+	ks := &Keystore{Client: &fakeWriter{err: errors.New("vault sealed")}, MountPath: "transit", TransitKey: "mykey"}
+
+	_, err := ks.GetKey("vault:v1:abcd")
+	if !gocbfieldcrypt.IsCryptoErrorType(err, gocbfieldcrypt.CryptoProviderDecryptFailed) {
+		t.Fatalf("Expected decrypt failed error, was: %v", err)
+	}
+}